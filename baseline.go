@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultBaseline is the baseline file, relative to the root config, if the config does not name one
+const defaultBaseline = "goverify.baseline.json"
+
+// baselineContext is how many lines around a finding are hashed into its fingerprint
+const baselineContext = 2
+
+// baselineEntry is a finding accepted by `goverify baseline write`.  The fingerprint does not include the line
+// number, so findings still match after the code around them moves.
+type baselineEntry struct {
+	Check       string `json:"check"`
+	File        string `json:"file,omitempty"`
+	Message     string `json:"message"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type baselineFile struct {
+	Findings []baselineEntry `json:"findings"`
+}
+
+// baseline suppresses the findings in a baseline file.  Each entry suppresses one finding.
+type baseline struct {
+	entries []baselineEntry
+	// remaining counts the entries of each fingerprint not yet matched by a finding
+	remaining map[string]int
+}
+
+func baselinePath(conf *config) string {
+	return filepath.Join(conf.rootPath, filepath.FromSlash(nonEmptyStr(conf.Baseline, defaultBaseline)))
+}
+
+// loadBaseline returns the config's baseline, or nil if it has none
+func loadBaseline(conf *config) (*baseline, error) {
+	filename := baselinePath(conf)
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) && conf.Baseline == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f baselineFile
+	if err = json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("unable to load baseline %s: %s", filename, err)
+	}
+	b := &baseline{
+		entries:   f.Findings,
+		remaining: make(map[string]int),
+	}
+	for _, e := range f.Findings {
+		b.remaining[e.Fingerprint]++
+	}
+	return b, nil
+}
+
+// suppress returns true, and uses up the entry, if the baseline has an unused entry for e
+func (b *baseline) suppress(e baselineEntry) bool {
+	if b == nil || b.remaining[e.Fingerprint] == 0 {
+		return false
+	}
+	b.remaining[e.Fingerprint]--
+	return true
+}
+
+// stale returns the entries of the checks that ran that no finding matched
+func (b *baseline) stale(ran map[string][]check) []baselineEntry {
+	if b == nil {
+		return nil
+	}
+	remaining := make(map[string]int, len(b.remaining))
+	for fingerprint, count := range b.remaining {
+		remaining[fingerprint] = count
+	}
+	var ret []baselineEntry
+	for _, e := range b.entries {
+		if len(ran[e.Check]) > 0 && remaining[e.Fingerprint] > 0 {
+			remaining[e.Fingerprint]--
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// findingLocation finds the file:line[:col] a finding points at
+var findingLocation = regexp.MustCompile(`(\S+\.go):(\d+)(?::\d+)?`)
+
+// locate fills in the file and line of the finding from its text.  Files are made relative to the root config.
+func (c *check) locate(f finding) finding {
+	matches := findingLocation.FindStringSubmatchIndex(f.line)
+	if matches == nil {
+		if strings.HasSuffix(strings.TrimSpace(f.line), ".go") {
+			// Tools like gofmt -l list only the file
+			f.file = c.rootRelative(filepath.ToSlash(strings.TrimSpace(f.line)))
+		}
+		return f
+	}
+	f.file = c.rootRelative(filepath.ToSlash(f.line[matches[2]:matches[3]]))
+	f.fileLine, _ = strconv.Atoi(f.line[matches[4]:matches[5]])
+	return f
+}
+
+// baselineEntry returns the entry that accepts the finding
+func (c *check) baselineEntry(f finding) baselineEntry {
+	message := strings.TrimSpace(f.line)
+	if matches := findingLocation.FindStringSubmatchIndex(message); matches != nil {
+		message = message[:matches[0]] + f.file + message[matches[1]:]
+	}
+	h := sha256.New()
+	for _, part := range []string{c.Name, f.rule, f.file, message, c.nearbyLines(f)} {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return baselineEntry{
+		Check:       c.Name,
+		File:        f.file,
+		Message:     message,
+		Fingerprint: hex.EncodeToString(h.Sum(nil))[:16],
+	}
+}
+
+// nearbyLines returns the trimmed lines around the finding, so the fingerprint changes when the code does
+func (c *check) nearbyLines(f finding) string {
+	if f.file == "" || f.fileLine == 0 {
+		return ""
+	}
+	content, err := ioutil.ReadFile(filepath.Join(c.rootPath, filepath.FromSlash(f.file)))
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(content), "\n")
+	var nearby []string
+	for i := f.fileLine - 1 - baselineContext; i <= f.fileLine-1+baselineContext; i++ {
+		if i >= 0 && i < len(lines) {
+			nearby = append(nearby, strings.TrimSpace(lines[i]))
+		}
+	}
+	return strings.Join(nearby, "\n")
+}
+
+// runReport collects what happened across every check of a run
+type runReport struct {
+	warnings warningSummary
+	baseline *baseline
+	// recording accepts every finding into recorded, rather than reporting them, for `goverify baseline write`
+	recording bool
+	recorded  []baselineEntry
+	// suppressions are the //goverify:ignore comments of the go files findings point at
+	suppressions *suppressions
+	// ran are the checks that ran, by their names and macros
+	ran map[string][]check
+}
+
+// applyBaseline removes the findings of res that are in the baseline, or records them if writing the baseline.
+// The result only fails if errors remain.
+func (r *runReport) applyBaseline(c check, res checkResult) checkResult {
+	if r.baseline == nil && !r.recording {
+		return res
+	}
+	res = outputFindings(res)
+	if len(res.findings) == 0 {
+		return res
+	}
+	var kept []finding
+	for _, f := range res.findings {
+		f = c.locate(f)
+		if f.severity == severityInfo {
+			kept = append(kept, f)
+			continue
+		}
+		entry := c.baselineEntry(f)
+		if r.recording {
+			r.recorded = append(r.recorded, entry)
+			continue
+		}
+		if !r.baseline.suppress(entry) {
+			kept = append(kept, f)
+		}
+	}
+	res.findings = kept
+	if res.originalErr != nil {
+		res.originalErr = checkFindings(kept)
+		res.output = formatFindings(kept)
+	}
+	return res
+}
+
+func (r *runReport) printStale(out io.Writer) {
+	stale := r.baseline.stale(r.ran)
+	if len(stale) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%d baseline entries no longer match a finding.  Run `goverify baseline write` to remove them:\n", len(stale))
+	for _, e := range stale {
+		fmt.Fprintf(out, "  %s: %s\n", e.Check, e.Message)
+	}
+}
+
+// writeBaseline runs every check and writes their findings to the baseline, so later runs only fail on new ones
+func (p *goverify) writeBaseline(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %s", strings.Join(args, " "))
+	}
+	conf, err := p.loadConfig()
+	if err != nil {
+		return err
+	}
+	report := &runReport{
+		recording: true,
+	}
+	report.suppressions = newSuppressions(conf.rootPath, p.logger.Printf)
+	err = p.runChecks(conf, report)
+	if scanErr := report.scanSuppressions(conf); scanErr != nil {
+		return scanErr
+	}
+	if suppressErr := report.suppressions.check(); suppressErr != nil {
+		return suppressErr
+	}
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(report.recorded, func(i, j int) bool {
+		a, b := report.recorded[i], report.recorded[j]
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Message < b.Message
+	})
+	out, err := json.MarshalIndent(baselineFile{Findings: report.recorded}, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := baselinePath(conf)
+	if err = ioutil.WriteFile(filename, append(out, '\n'), 0644); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.out(), "Wrote %d findings to %s\n", len(report.recorded), filename)
+	return err
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coverProfileVar is replaced, when the check runs, with a temporary file for the check to write a coverprofile
+// to.  Validators that implement profileValidator check the coverprofile rather than the output.
+const coverProfileVar = "${coverprofile}"
+
+// profileValidator is a validator that checks the coverprofile a check writes to ${coverprofile}
+type profileValidator interface {
+	CheckProfile(profile []byte, stdout *bytes.Buffer, stderr *bytes.Buffer) error
+}
+
+// coverBlock is one block of a coverprofile: a range of statements in a file, and how many times they ran
+type coverBlock struct {
+	// file is the import path of the file, like github.com/cep21/goverify/goverify.go
+	file      string
+	startLine int
+	startCol  int
+	endLine   int
+	endCol    int
+	// statements is how many statements the block has
+	statements int
+	count      int
+}
+
+var coverBlockPattern = regexp.MustCompile(`^(.+):(\d+)\.(\d+),(\d+)\.(\d+) (\d+) (\d+)$`)
+
+// parseCoverProfile parses the blocks of a coverprofile.  A block listed more than once, as happens with -coverpkg
+// or profiles of several runs appended together, is merged into one that ran if any of them ran.
+func parseCoverProfile(content []byte) ([]coverBlock, error) {
+	var blocks []coverBlock
+	index := make(map[string]int)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		matches := coverBlockPattern.FindStringSubmatch(line)
+		if matches == nil {
+			return nil, fmt.Errorf("line %d of coverprofile: unable to parse %q", i+1, line)
+		}
+		nums := make([]int, 0, 6)
+		for _, m := range matches[2:] {
+			n, err := strconv.Atoi(m)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of coverprofile: %s", i+1, err)
+			}
+			nums = append(nums, n)
+		}
+		b := coverBlock{
+			file:       matches[1],
+			startLine:  nums[0],
+			startCol:   nums[1],
+			endLine:    nums[2],
+			endCol:     nums[3],
+			statements: nums[4],
+			count:      nums[5],
+		}
+		key := strings.SplitN(line, " ", 2)[0]
+		if existing, exists := index[key]; exists {
+			blocks[existing].count += b.count
+			continue
+		}
+		index[key] = len(blocks)
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// coverageCounts are how many statements there are and how many of them ran
+type coverageCounts struct {
+	statements int
+	covered    int
+}
+
+func (c coverageCounts) add(b coverBlock) coverageCounts {
+	c.statements += b.statements
+	if b.count > 0 {
+		c.covered += b.statements
+	}
+	return c
+}
+
+// percent is the percent of statements covered.  Nothing to cover is fully covered.
+func (c coverageCounts) percent() float64 {
+	if c.statements == 0 {
+		return 100
+	}
+	return 100 * float64(c.covered) / float64(c.statements)
+}
+
+// coverageSummary is the coverage of each file, each package and in total
+type coverageSummary struct {
+	files    map[string]coverageCounts
+	packages map[string]coverageCounts
+	total    coverageCounts
+}
+
+func summarizeCoverage(blocks []coverBlock) coverageSummary {
+	s := coverageSummary{
+		files:    make(map[string]coverageCounts),
+		packages: make(map[string]coverageCounts),
+	}
+	for _, b := range blocks {
+		s.files[b.file] = s.files[b.file].add(b)
+		pkg := path.Dir(b.file)
+		s.packages[pkg] = s.packages[pkg].add(b)
+		s.total = s.total.add(b)
+	}
+	return s
+}
+
+func sortedKeys(m map[string]coverageCounts) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s coverageSummary) packageNames() []string {
+	return sortedKeys(s.packages)
+}
+
+func (s coverageSummary) fileNames() []string {
+	return sortedKeys(s.files)
+}
+
+// replaceCoverProfile replaces ${coverprofile} in args with a new temporary file, and returns the file.  It
+// returns the empty string if args do not write a coverprofile.
+func replaceCoverProfile(args []string) (string, error) {
+	profile := ""
+	for i := range args {
+		if !strings.Contains(args[i], coverProfileVar) {
+			continue
+		}
+		if profile == "" {
+			dir, err := ioutil.TempDir("", "goverify")
+			if err != nil {
+				return "", err
+			}
+			profile = filepath.Join(dir, "coverage.out")
+		}
+		args[i] = strings.Replace(args[i], coverProfileVar, profile, -1)
+	}
+	return profile, nil
+}
+
+type coverageValidator struct {
+	validator
+	RequiredCoverage float64 `json:"coverage"`
+	// Packages are the required coverages of packages by pattern, like internal/legacy/... for a directory and
+	// the packages below it.  The longest matching pattern wins, and other packages use coverage.
+	Packages map[string]float64 `json:"packages"`
+	// TotalCoverage is the required coverage of all the packages together
+	TotalCoverage float64  `json:"totalCoverage"`
+	IgnoreDir     []string `json:"ignoreDir"`
+	// Baseline fails the check if coverage drops too far from a baseline
+	Baseline *coverageBaseline `json:"baseline"`
+	// Patch requires coverage of the lines changed since a git ref
+	Patch *patchCoverage `json:"patch"`
+	// HTML is where to write an HTML coverage report of every package, relative to the check's directory
+	HTML string `json:"html"`
+	// Cobertura is where to write a Cobertura XML coverage report, relative to the check's directory
+	Cobertura string `json:"cobertura"`
+	// excludeDirs are directories, relative to the root config, whose packages are checked by a nested config
+	excludeDirs []string
+	// dir is the directory of the check
+	dir string
+	// baseProfile is the coverprofile of the baseline ref, if the baseline is a git ref
+	baseProfile []byte
+	// changedLines are the lines changed since the patch base, by file relative to dir
+	changedLines map[string][]int
+	logf         func(format string, args ...interface{})
+}
+
+type coverageError struct {
+	// pkg is the package with too little coverage, or empty for the total
+	pkg      string
+	seen     float64
+	required float64
+	// files are the files of the package with too little coverage, if known
+	files []string
+}
+
+func (c *coverageError) Error() string {
+	if c.pkg == "" {
+		return fmt.Sprintf("Total coverage %f less than required %f", c.seen, c.required)
+	}
+	msg := fmt.Sprintf("Coverage %f of %s less than required %f", c.seen, c.pkg, c.required)
+	for _, file := range c.files {
+		msg += "\n  " + file
+	}
+	return msg
+}
+
+// coverageErrors are every package, and the total, with too little coverage
+type coverageErrors []error
+
+func (c coverageErrors) Error() string {
+	msgs := make([]string, 0, len(c))
+	for _, err := range c {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (c *coverageValidator) MergePropertiesFrom(val json.RawMessage) {
+	if val == nil {
+		return
+	}
+	var other coverageValidator
+	if err := json.Unmarshal(val, &other); err != nil {
+		return
+	}
+	if other.RequiredCoverage != 0 {
+		c.RequiredCoverage = other.RequiredCoverage
+	}
+	if other.TotalCoverage != 0 {
+		c.TotalCoverage = other.TotalCoverage
+	}
+	if len(other.Packages) > 0 {
+		c.Packages = other.Packages
+	}
+	if other.Baseline != nil {
+		c.Baseline = other.Baseline
+	}
+	if other.Patch != nil {
+		c.Patch = other.Patch
+	}
+	c.HTML = nonEmptyStr(other.HTML, c.HTML)
+	c.Cobertura = nonEmptyStr(other.Cobertura, c.Cobertura)
+	c.IgnoreDir = nonEmptyStrArr(other.IgnoreDir, c.IgnoreDir)
+}
+
+// packageMatches returns true if the import path pkg matches pattern.  Patterns are import paths, or the end of
+// one, and a pattern ending in /... also matches the packages below it.
+func packageMatches(pkg string, pattern string) bool {
+	if strings.HasSuffix(pattern, "/...") {
+		dir := strings.TrimSuffix(pattern, "/...")
+		return packageMatches(pkg, dir) || strings.HasPrefix(pkg, dir+"/") || strings.Contains(pkg, "/"+dir+"/")
+	}
+	return pkg == pattern || strings.HasSuffix(pkg, "/"+pattern)
+}
+
+// requiredCoverage returns the coverage pkg needs: that of the longest pattern in Packages matching it, or
+// RequiredCoverage
+func (c *coverageValidator) requiredCoverage(pkg string) float64 {
+	required := c.RequiredCoverage
+	longest := -1
+	for pattern, coverage := range c.Packages {
+		// internal/legacy/old is more specific than internal/legacy/...
+		length := len(strings.TrimSuffix(pattern, "/..."))
+		if length > longest && packageMatches(pkg, pattern) {
+			required = coverage
+			longest = length
+		}
+	}
+	return required
+}
+
+// ignored returns true if the package is not checked by this validator
+func (c *coverageValidator) ignored(pkg string) bool {
+	return containsName(pkg, c.IgnoreDir) || packageInDirs(pkg, c.excludeDirs)
+}
+
+// CheckProfile checks the coverage of each package, and the total, from the coverprofile of the check.  Every
+// statement counts the same towards the total.
+func (c *coverageValidator) CheckProfile(profile []byte, stdout *bytes.Buffer, stderr *bytes.Buffer) error {
+	blocks, err := parseCoverProfile(profile)
+	if err != nil {
+		return err
+	}
+	blocks = c.checkedBlocks(blocks)
+	if err = c.writeReports(blocks); err != nil {
+		return err
+	}
+	summary := summarizeCoverage(blocks)
+	var errs coverageErrors
+	for _, pkg := range summary.packageNames() {
+		seen := summary.packages[pkg].percent()
+		required := c.requiredCoverage(pkg)
+		if seen+.009 > required {
+			continue
+		}
+		coverageErr := &coverageError{
+			pkg:      pkg,
+			seen:     seen,
+			required: required,
+		}
+		for _, file := range summary.fileNames() {
+			if path.Dir(file) == pkg && summary.files[file].percent()+.009 <= required {
+				coverageErr.files = append(coverageErr.files, fmt.Sprintf("%s: %.1f%%", path.Base(file), summary.files[file].percent()))
+			}
+		}
+		errs = append(errs, coverageErr)
+	}
+	if summary.total.statements > 0 && summary.total.percent()+.009 <= c.TotalCoverage {
+		errs = append(errs, &coverageError{
+			seen:     summary.total.percent(),
+			required: c.TotalCoverage,
+		})
+	}
+	if c.Patch != nil {
+		if err := c.checkPatch(blocks); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Baseline != nil {
+		base, err := c.baselineSnapshot(c.baseProfile)
+		if err != nil {
+			return err
+		}
+		regression, table := compareCoverage(base, snapshotOf(summary), c.Baseline.MaxDrop)
+		if regression != nil {
+			errs = append(errs, regression)
+		} else if c.logf != nil {
+			c.logf("Coverage compared to the baseline:\n%s", table)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkedBlocks returns the blocks of the packages the validator checks
+func (c *coverageValidator) checkedBlocks(blocks []coverBlock) []coverBlock {
+	var kept []coverBlock
+	for _, b := range blocks {
+		if !c.ignored(path.Dir(b.file)) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// Check checks the coverage printed by `go test -cover`, for checks that do not write a coverprofile
+func (c *coverageValidator) Check(stdout *bytes.Buffer, stderr *bytes.Buffer) error {
+	if c.TotalCoverage != 0 {
+		return fmt.Errorf("totalCoverage needs statement counts, which the coverage printed by go test does not have: write a coverprofile to %s instead", coverProfileVar)
+	}
+	pattern := regexp.MustCompile(`coverage: ([0-9\.]+)% of statements`)
+	var errs coverageErrors
+	for _, coverout := range strings.Split(stdout.String(), "\n") {
+		if coverout == "" {
+			continue
+		}
+		matchPercent, err := func() (float64, error) {
+			if strings.Contains(coverout, "[no test files]") {
+				return 0.0, nil
+			}
+			matches := pattern.FindStringSubmatch(coverout)
+			if matches == nil {
+				return 0.0, fmt.Errorf("unable to find match in string: %s", coverout)
+			}
+			return strconv.ParseFloat(matches[1], 64)
+		}()
+		if err != nil {
+			return err
+		}
+		testPath := ""
+		parts := strings.Split(coverout, "\t")
+		if len(parts) > 1 {
+			testPath = strings.TrimSpace(parts[1])
+			if c.ignored(testPath) {
+				continue
+			}
+		}
+		if required := c.requiredCoverage(testPath); matchPercent+.009 <= required {
+			errs = append(errs, &coverageError{
+				pkg:      testPath,
+				seen:     matchPercent,
+				required: required,
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
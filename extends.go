@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// argOps edit inherited args, rather than replacing them the way setting args does.  Removes are applied first,
+// then prepends and appends.
+type argOps struct {
+	ArgsAppend  []string `json:"argsAppend"`
+	ArgsPrepend []string `json:"argsPrepend"`
+	ArgsRemove  []string `json:"argsRemove"`
+}
+
+func (o argOps) empty() bool {
+	return len(o.ArgsAppend) == 0 && len(o.ArgsPrepend) == 0 && len(o.ArgsRemove) == 0
+}
+
+func (o argOps) apply(args []string) []string {
+	if o.empty() {
+		return args
+	}
+	ret := make([]string, 0, len(o.ArgsPrepend)+len(args)+len(o.ArgsAppend))
+	ret = append(ret, o.ArgsPrepend...)
+	for _, arg := range args {
+		if !containsStr(o.ArgsRemove, arg) {
+			ret = append(ret, arg)
+		}
+	}
+	return append(ret, o.ArgsAppend...)
+}
+
+// then returns the operators that apply o and then next
+func (o argOps) then(next argOps) argOps {
+	return argOps{
+		ArgsPrepend: append(append([]string{}, next.ArgsPrepend...), removeStrs(o.ArgsPrepend, next.ArgsRemove)...),
+		ArgsAppend:  append(removeStrs(o.ArgsAppend, next.ArgsRemove), next.ArgsAppend...),
+		ArgsRemove:  append(append([]string{}, o.ArgsRemove...), next.ArgsRemove...),
+	}
+}
+
+// mergeArgs returns the args of a child that overrides parent.  Args set by either are edited by their operators
+// now, but operators with no args to edit are combined, to edit the args both inherit later.
+func mergeArgs(childArgs []string, childOps argOps, parentArgs []string, parentOps argOps) ([]string, argOps) {
+	if len(childArgs) > 0 {
+		return childOps.apply(childArgs), argOps{}
+	}
+	if len(parentArgs) > 0 {
+		return childOps.apply(parentOps.apply(parentArgs)), argOps{}
+	}
+	return nil, parentOps.then(childOps)
+}
+
+func removeStrs(args []string, remove []string) []string {
+	ret := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !containsStr(remove, arg) {
+			ret = append(ret, arg)
+		}
+	}
+	return ret
+}
+
+func containsStr(searchIn []string, s string) bool {
+	for _, v := range searchIn {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func flattenCheckCmd(c *checkCmd) *checkCmd {
+	if c == nil || c.argOps.empty() {
+		return c
+	}
+	return &checkCmd{
+		Cmd:  c.Cmd,
+		Args: c.argOps.apply(c.Args),
+	}
+}
+
+// flattenArgOps applies arg operators that had nothing to merge with, so they act on the check's own args
+func (c *check) flattenArgOps() {
+	c.Fix = flattenCheckCmd(c.Fix)
+	c.Check = flattenCheckCmd(c.Check)
+	c.Install = flattenCheckCmd(c.Install)
+	for i := range c.Then {
+		c.Then[i] = flattenCheckCmd(c.Then[i])
+	}
+	if c.Each != nil && !c.Each.argOps.empty() {
+		each := *c.Each
+		each.Args = each.argOps.apply(each.Args)
+		each.argOps = argOps{}
+		c.Each = &each
+	}
+}
+
+// mergeValidatorJSON returns the validator settings of parent overridden by the settings in child
+func mergeValidatorJSON(child, parent json.RawMessage) (json.RawMessage, error) {
+	if child == nil {
+		return parent, nil
+	}
+	if parent == nil {
+		return child, nil
+	}
+	merged := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(parent, &merged); err != nil {
+		return nil, err
+	}
+	var childFields map[string]json.RawMessage
+	if err := json.Unmarshal(child, &childFields); err != nil {
+		return nil, err
+	}
+	for k, v := range childFields {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
+// resolveExtends merges every macro with the chain of macros it extends
+func resolveExtends(allMacros map[string]check) error {
+	resolved := make(map[string]bool, len(allMacros))
+	for name := range allMacros {
+		if err := resolveMacro(allMacros, name, resolved, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveMacro(allMacros map[string]check, name string, resolved map[string]bool, chain []string) error {
+	if resolved[name] {
+		return nil
+	}
+	chain = append(chain, name)
+	macro := allMacros[name]
+	if macro.Extends != "" {
+		if containsStr(chain, macro.Extends) {
+			return fmt.Errorf("macro cycle: %s -> %s", strings.Join(chain, " -> "), macro.Extends)
+		}
+		if _, exists := allMacros[macro.Extends]; !exists {
+			return fmt.Errorf("macro %s extends unknown macro %s", name, macro.Extends)
+		}
+		if err := resolveMacro(allMacros, macro.Extends, resolved, chain); err != nil {
+			return err
+		}
+		parent := allMacros[macro.Extends]
+		macro.mergePropertiesFrom(parent)
+		var err error
+		if macro.Validator, err = mergeValidatorJSON(macro.Validator, parent.Validator); err != nil {
+			return fmt.Errorf("macro %s: %s", name, err)
+		}
+	}
+	macro.flattenArgOps()
+	allMacros[name] = macro
+	resolved[name] = true
+	return nil
+}
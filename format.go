@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configNames are the config files searched for, in order, when -config is not given
+var configNames = []string{"goverify.json", "goverify.yaml", "goverify.yml", "goverify.toml"}
+
+// decodeConfigFile decodes the content of a config or macro file into v, picking the format from the file's
+// extension.  Files with any other extension are JSON.  YAML and TOML are converted to JSON first, so every format
+// decodes through the same json tags.
+func decodeConfigFile(filename string, content []byte, v interface{}) error {
+	var generic interface{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &generic); err != nil {
+			return err
+		}
+	case ".toml":
+		var m map[string]interface{}
+		if err := toml.Unmarshal(content, &m); err != nil {
+			return err
+		}
+		generic = m
+	default:
+		return json.Unmarshal(content, v)
+	}
+	asJSON, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(asJSON, v)
+}
+
+// findConfigFile returns the single config file in dir.  Having more than one is an error, since it would be
+// unclear which one applies.  If there is none, it is an error only if required.
+func findConfigFile(dir string, required bool) (string, error) {
+	var found []string
+	for _, name := range configNames {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			found = append(found, filename)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if len(found) == 0 {
+		if !required {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to find a config file: expected one of %s", strings.Join(configNames, ", "))
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("found multiple config files, use -config to pick one: %s", strings.Join(found, ", "))
+	}
+	return found[0], nil
+}
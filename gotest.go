@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// testEvent is a single line of `go test -json` output, as documented by `go doc test2json`
+type testEvent struct {
+	Action  string  `json:"Action"`
+	Package string  `json:"Package"`
+	Test    string  `json:"Test"`
+	Elapsed float64 `json:"Elapsed"`
+	Output  string  `json:"Output"`
+}
+
+func (e *testEvent) name() string {
+	if e.Test == "" {
+		return e.Package
+	}
+	return e.Package + "." + e.Test
+}
+
+// parseTestEvents decodes the JSON event stream from `go test -json`.  Lines that are not JSON, like build
+// output from older go versions, are skipped.
+func parseTestEvents(stdout *bytes.Buffer) ([]testEvent, error) {
+	var events []testEvent
+	scanner := bufio.NewScanner(bytes.NewReader(stdout.Bytes()))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var e testEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// testResult is the outcome of one test, or of a package outside of its tests
+type testResult struct {
+	pkg string
+	// test is empty for the package itself
+	test string
+	// action is pass, fail or skip, or empty if the test never finished
+	action  string
+	elapsed float64
+	output  []string
+}
+
+func (r *testResult) name() string {
+	if r.test == "" {
+		return r.pkg
+	}
+	return r.pkg + "." + r.test
+}
+
+// topLevel is the name of the test to run to rerun this one, which for a subtest is its parent
+func (r *testResult) topLevel() string {
+	return strings.SplitN(r.test, "/", 2)[0]
+}
+
+// testRun is the results of one `go test -json` run, in the order their tests started
+type testRun struct {
+	results []*testResult
+	byName  map[string]*testResult
+}
+
+func newTestRun(events []testEvent) *testRun {
+	t := &testRun{
+		byName: make(map[string]*testResult),
+	}
+	for _, e := range events {
+		r, exists := t.byName[e.name()]
+		if !exists {
+			r = &testResult{
+				pkg:  e.Package,
+				test: e.Test,
+			}
+			t.byName[e.name()] = r
+			t.results = append(t.results, r)
+		}
+		switch e.Action {
+		case "output":
+			r.output = append(r.output, strings.TrimRight(e.Output, "\n"))
+		case "pass", "fail", "skip":
+			r.action = e.Action
+			r.elapsed = e.Elapsed
+		}
+	}
+	return t
+}
+
+// failed returns the tests that failed.  A package fails when any of its tests do, so a package is only returned
+// when the failure is its own, like a build error or a panic outside a test.
+func (t *testRun) failed() []*testResult {
+	packagesWithFailedTests := make(map[string]bool)
+	for _, r := range t.results {
+		if r.test != "" && r.action == "fail" {
+			packagesWithFailedTests[r.pkg] = true
+		}
+	}
+	var ret []*testResult
+	for _, r := range t.results {
+		if r.action == "fail" && (r.test != "" || !packagesWithFailedTests[r.pkg]) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// counts returns how many tests passed, failed and were skipped
+func (t *testRun) counts() (passed int, failed int, skipped int) {
+	for _, r := range t.results {
+		switch {
+		case r.test == "":
+		case r.action == "pass":
+			passed++
+		case r.action == "fail":
+			failed++
+		case r.action == "skip":
+			skipped++
+		}
+	}
+	return passed, failed, skipped
+}
+
+type testFailureError struct {
+	failed []string
+}
+
+func (t *testFailureError) Error() string {
+	return fmt.Sprintf("%d failed: %s", len(t.failed), strings.Join(t.failed, ", "))
+}
+
+func newTestFailureError(failed []*testResult) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(failed))
+	for _, r := range failed {
+		names = append(names, r.name())
+	}
+	return &testFailureError{
+		failed: names,
+	}
+}
+
+// failureOutput is the output of each failed test, grouped by test
+func failureOutput(failed []*testResult) string {
+	var buf bytes.Buffer
+	for _, r := range failed {
+		fmt.Fprintf(&buf, "--- FAIL: %s (%.2fs)\n", r.name(), r.elapsed)
+		for _, line := range r.output {
+			if strings.HasPrefix(line, "=== ") || strings.HasPrefix(line, "--- FAIL") {
+				continue
+			}
+			// go test already indents the output of a test
+			fmt.Fprintf(&buf, "%s\n", line)
+		}
+	}
+	return buf.String()
+}
+
+// testValidator checks the output of `go test -json`.  Failed tests may be run again, and those that pass on
+// a retry are reported as flaky rather than failing the check.
+type testValidator struct {
+	validator
+	// Retries is how many times to run failed tests again
+	Retries int `json:"retries"`
+}
+
+func (c *testValidator) MergePropertiesFrom(val json.RawMessage) {
+	if val == nil {
+		return
+	}
+	var other testValidator
+	if err := json.Unmarshal(val, &other); err != nil {
+		return
+	}
+	if other.Retries != 0 {
+		c.Retries = other.Retries
+	}
+}
+
+func (c *testValidator) Check(stdout *bytes.Buffer, stderr *bytes.Buffer) error {
+	events, err := parseTestEvents(stdout)
+	if err != nil {
+		return err
+	}
+	return newTestFailureError(newTestRun(events).failed())
+}
+
+// checkTests checks the output of a `go test -json` check, even if it failed, and retries the tests that failed
+func (p *goverify) checkTests(c check, v *testValidator, toRun *checkCmd, param string, stdout *bytes.Buffer, stderr *bytes.Buffer, runErr error) checkResult {
+	output := stdout.String() + stderr.String()
+	events, err := parseTestEvents(stdout)
+	if err != nil {
+		return checkResult{
+			originalErr: err,
+			output:      output,
+		}
+	}
+	run := newTestRun(events)
+	for _, r := range run.results {
+		if r.test != "" {
+			p.logger.Printf("%s %s (%.2fs)", r.action, r.name(), r.elapsed)
+		}
+	}
+	passed, failedCount, skipped := run.counts()
+	p.logger.Printf("%d passed, %d failed, %d skipped", passed, failedCount, skipped)
+	failed := run.failed()
+	if runErr != nil && len(failed) == 0 {
+		// go test failed without a test or package failing, like when it could not start
+		return checkResult{
+			originalErr: runErr,
+			output:      output,
+		}
+	}
+	var findings []finding
+	for attempt := 1; attempt <= v.Retries && len(failed) > 0; attempt++ {
+		retry := p.rerunTests(c, toRun, param, failed)
+		if retry == nil {
+			break
+		}
+		var stillFailed []*testResult
+		for _, r := range failed {
+			if r.test != "" && retry.byName[r.name()] != nil && retry.byName[r.name()].action == "pass" {
+				findings = append(findings, finding{
+					severity: severityWarning,
+					rule:     "flaky",
+					line:     fmt.Sprintf("%s is flaky: it passed on retry %d", r.name(), attempt),
+				})
+				continue
+			}
+			stillFailed = append(stillFailed, r)
+		}
+		failed = stillFailed
+	}
+	for _, r := range failed {
+		findings = append(findings, finding{
+			severity: severityError,
+			rule:     "fail",
+			line:     r.name() + " failed",
+		})
+	}
+	if len(failed) == 0 {
+		return checkResult{
+			output:   output,
+			findings: findings,
+		}
+	}
+	var flaky strings.Builder
+	for _, f := range findings {
+		if f.rule == "flaky" {
+			fmt.Fprintf(&flaky, "%s\n", f.line)
+		}
+	}
+	return checkResult{
+		originalErr: newTestFailureError(failed),
+		output:      flaky.String() + failureOutput(failed),
+		findings:    findings,
+	}
+}
+
+// rerunTests runs the check again for only the failed tests.  It returns nil if no failed test can be rerun.
+func (p *goverify) rerunTests(c check, toRun *checkCmd, param string, failed []*testResult) *testRun {
+	var names []string
+	for _, r := range failed {
+		if r.test != "" && !containsStr(names, regexp.QuoteMeta(r.topLevel())) {
+			names = append(names, regexp.QuoteMeta(r.topLevel()))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	cmdToRun, args := c.commandLine(toRun, param)
+	args, err := p.scopePackages(c, args)
+	if err != nil || args == nil {
+		return nil
+	}
+	// The last -run wins, and test flags may follow the packages
+	args = append(args, "-run", "^("+strings.Join(names, "|")+")$")
+	p.logger.Printf("Retrying failed tests: %s %s", cmdToRun, args)
+	cmd := c.command(cmdToRun, args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, p.cmdStdout)
+	cmd.Stderr = p.cmdStderr
+	if err := p.run(cmd); err != nil {
+		p.logger.Printf("Retried tests failed: %s", err)
+	}
+	events, err := parseTestEvents(&stdout)
+	if err != nil {
+		p.logger.Printf("Unable to parse retried tests: %s", err)
+		return nil
+	}
+	return newTestRun(events)
+}
@@ -13,9 +13,8 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -24,6 +23,8 @@ type checkResult struct {
 	checkName   string
 	output      string
 	originalErr error
+	// findings are the problems found by a findingsValidator, including warnings that do not fail the check
+	findings []finding
 }
 
 func (c *checkResult) Error() string {
@@ -31,13 +32,16 @@ func (c *checkResult) Error() string {
 }
 
 type eachFileLister struct {
-	Cmd       string   `json:"cmd"`
-	Args      []string `json:"args"`
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args"`
+	argOps
 	IgnoreDir []string `json:"ignoreDir"`
+	// Dirs runs the check once for each directory containing a listed file, rather than once per file
+	Dirs bool `json:"dirs"`
 }
 
 func (e *eachFileLister) String() string {
-	return fmt.Sprintf("Cmd: %s | Args: %s | IgnoreDir: %s", e.Cmd, e.Args, e.IgnoreDir)
+	return fmt.Sprintf("Cmd: %s | Args: %s | IgnoreDir: %s | Dirs: %t", e.Cmd, e.Args, e.IgnoreDir, e.Dirs)
 }
 
 func mergeEachFileLister(e1, e2 *eachFileLister) *eachFileLister {
@@ -47,10 +51,13 @@ func mergeEachFileLister(e1, e2 *eachFileLister) *eachFileLister {
 	if e2 == nil {
 		return e1
 	}
+	args, ops := mergeArgs(e1.Args, e1.argOps, e2.Args, e2.argOps)
 	return &eachFileLister{
 		Cmd:       nonEmptyStr(e1.Cmd, e2.Cmd),
-		Args:      nonEmptyStrArr(e1.Args, e2.Args),
+		Args:      args,
+		argOps:    ops,
 		IgnoreDir: nonEmptyStrArr(e1.IgnoreDir, e2.IgnoreDir),
+		Dirs:      e1.Dirs || e2.Dirs,
 	}
 }
 
@@ -78,6 +85,7 @@ func (e *eachFileLister) filteredFilename(filename string) bool {
 type checkCmd struct {
 	Cmd  string   `json:"cmd"`
 	Args []string `json:"args"`
+	argOps
 }
 
 func (c *checkCmd) String() string {
@@ -91,9 +99,11 @@ func mergeCheckCmd(c1, c2 *checkCmd) *checkCmd {
 	if c2 == nil {
 		return c1
 	}
+	args, ops := mergeArgs(c1.Args, c1.argOps, c2.Args, c2.argOps)
 	return &checkCmd{
-		Cmd:  nonEmptyStr(c1.Cmd, c2.Cmd),
-		Args: nonEmptyStrArr(c1.Args, c2.Args),
+		Cmd:    nonEmptyStr(c1.Cmd, c2.Cmd),
+		Args:   args,
+		argOps: ops,
 	}
 }
 
@@ -104,19 +114,56 @@ type check struct {
 	Fix     *checkCmd `json:"fix"`
 	Check   *checkCmd `json:"check"`
 	Install *checkCmd `json:"install"`
+	// Then are commands run, in order, after check (or fix) succeeds.  Each must also pass the validator.
+	Then []*checkCmd `json:"then"`
+
+	Gotool  string `json:"gotool"`
+	Modtool string `json:"modtool"`
+	Godep   *bool  `json:"godep"`
+	Macro   string `json:"macro"`
+	// Extends is the macro a macro inherits unset properties from
+	Extends string `json:"extends"`
+
+	// Params are the named parameters, with their defaults, that args reference as {{.name}}
+	Params map[string]interface{} `json:"params"`
+	// With sets parameters of the macro this check uses
+	With map[string]interface{} `json:"with"`
 
-	Gotool string `json:"gotool"`
-	Godep  *bool  `json:"godep"`
-	Macro  string `json:"macro"`
+	// Deprecated is set on macros that should no longer be used, and is the warning shown when they are
+	Deprecated string `json:"deprecated"`
+	// Disabled turns off a check, usually one inherited from a parent config
+	Disabled bool `json:"disabled"`
+	// Severity is error, warning or info.  Defaults to error.  Only errors fail the run.
+	Severity string `json:"severity"`
+
+	// Env are environment variables set, on top of goverify's own, for every command of the check
+	Env map[string]string `json:"env"`
+	// Dir is where the check's commands run, relative to the root config.  Defaults to the root config's directory.
+	Dir string `json:"dir"`
+	// When limits the check to some platforms
+	When *condition `json:"when"`
 
 	Each *eachFileLister `json:"each"`
 
 	Validator       json.RawMessage `json:"validate"`
 	validateDecoded cmdValidator
+
+	// scopeDir is the directory, relative to the root config, of the nested config the check is defined by.
+	// Empty for the root config.
+	scopeDir string
+	// scopeWorkDir is where a check that does not run on each file runs.  Empty for the current directory.
+	scopeWorkDir string
+	// excludeScopes are directories of nested configs that define this check themselves
+	excludeScopes []string
+	// workDir is the absolute directory the check's commands run in, once resolved
+	workDir  string
+	rootPath string
+	// definedBy is where the check was defined, nested configs first
+	definedBy []definitionLevel
 }
 
 func (c *check) String() string {
-	return fmt.Sprintf("Name: %s | Cmd: %s | Fix: %s | Check: %s | Install: %s | Gotool: %s | Macro: %s | Each: %s | Validator: %s", c.Name, c.Cmd, c.Fix, c.Check, c.Install, c.Gotool, c.Macro, c.Each, c.Validator)
+	return fmt.Sprintf("Name: %s | Cmd: %s | Fix: %s | Check: %s | Install: %s | Then: %s | Gotool: %s | Modtool: %s | Macro: %s | With: %v | Each: %s | Validator: %s", c.Name, c.Cmd, c.Fix, c.Check, c.Install, c.Then, c.Gotool, c.Modtool, c.Macro, c.With, c.Each, c.Validator)
 }
 
 func (c *check) mergePropertiesFrom(macroDef check) {
@@ -126,8 +173,19 @@ func (c *check) mergePropertiesFrom(macroDef check) {
 	c.Fix = mergeCheckCmd(c.Fix, macroDef.Fix)
 	c.Check = mergeCheckCmd(c.Check, macroDef.Check)
 	c.Install = mergeCheckCmd(c.Install, macroDef.Install)
+	if len(c.Then) == 0 {
+		c.Then = macroDef.Then
+	}
 
 	c.Gotool = nonEmptyStr(c.Gotool, macroDef.Gotool)
+	c.Modtool = nonEmptyStr(c.Modtool, macroDef.Modtool)
+	c.Dir = nonEmptyStr(c.Dir, macroDef.Dir)
+	c.Severity = nonEmptyStr(c.Severity, macroDef.Severity)
+	c.Env = mergeEnv(c.Env, macroDef.Env)
+	if c.When == nil {
+		c.When = macroDef.When
+	}
+	c.Params = mergeParams(c.Params, macroDef.Params)
 	if c.Godep == nil {
 		c.Godep = macroDef.Godep
 	}
@@ -159,85 +217,221 @@ type validator struct {
 }
 
 type config struct {
-	Checks           []check          `json:"checks"`
-	Macros           map[string]check `json:"macros"`
-	IgnoreDir        []string         `json:"ignoreDir"`
-	rootPath         string
+	// Schema lets editors find the JSON Schema of the config
+	Schema string           `json:"$schema"`
+	Checks []check          `json:"checks"`
+	Macros map[string]check `json:"macros"`
+	// MacroFiles are extra files of macros, relative to the config file, shared between repositories
+	MacroFiles []string `json:"macroFiles"`
+	IgnoreDir  []string `json:"ignoreDir"`
+	// Profiles pick the checks to run with -profile, and change their settings
+	Profiles map[string]profile `json:"profiles"`
+	// DefaultProfile is used when -profile is not given.  Every check runs if neither is set.
+	DefaultProfile string `json:"defaultProfile"`
+	// Baseline is the file of accepted findings, relative to the config.  Defaults to goverify.baseline.json.
+	Baseline   string `json:"baseline"`
+	rootPath   string
+	configFile string
+	// nested are the configs of directories below rootPath, parents first
+	nested []nestedConfig
+	vars   *varExpander
+	// profile is the selected profile, if any
+	profile *selectedProfile
+	// macroDefs are the macros as defined, before extends is resolved, and macroSources where each was defined
+	macroDefs        map[string]check
+	macroSources     map[string]string
 	SimultaneousRuns int `json:"simultaneousRuns"`
 	GlobalIgnore     []string
 }
 
 type goverify struct {
 	configFile string
-	rootDir    string
 	logger     *log.Logger
 
 	cmdStdout io.Writer
 	cmdStderr io.Writer
+	// warnOutput is where warnings are written.  Defaults to stderr.
+	warnOutput io.Writer
+	// output is where subcommands write.  Defaults to stdout.
+	output io.Writer
+
+	// args are the command line arguments after the flags, which pick a subcommand
+	args []string
 
 	run     runCommand
 	fix     bool
 	verbose bool
+	profile string
+	// maxWarnings fails the run if there are more warnings.  Negative allows any number.
+	maxWarnings int
 }
 
 var primaryMain = goverify{
-	run: run,
+	run:         run,
+	maxWarnings: -1,
 }
 
 func init() {
-	flag.StringVar(&primaryMain.configFile, "config", "goverify.json", "config file for building")
+	flag.StringVar(&primaryMain.configFile, "config", "", "config file for building.  Defaults to the goverify.json, .yaml, .yml or .toml file in the current directory")
 	flag.BoolVar(&primaryMain.fix, "fix", false, "If true, also fix the code if it can")
 	flag.BoolVar(&primaryMain.verbose, "v", false, "If true, verbose output")
-	flag.Parse()
+	flag.IntVar(&primaryMain.maxWarnings, "max-warnings", -1, "Fail if there are more than this many warnings.  Negative allows any number")
+	flag.StringVar(&primaryMain.profile, "profile", "", "Profile of checks to run.  Defaults to the config's defaultProfile")
 }
 
 func main() {
+	flag.Parse()
+	primaryMain.args = flag.Args()
 	if err := primaryMain.main(); err != nil {
 		fmt.Printf("%s\n", err)
 		os.Exit(1)
 	}
 }
 
+func (p *goverify) out() io.Writer {
+	if p.output == nil {
+		return os.Stdout
+	}
+	return p.output
+}
+
+// subcommands are run with `goverify <words...>` instead of running the checks
+var subcommands = []struct {
+	words []string
+	run   func(p *goverify, args []string) error
+}{
+	{[]string{"config", "schema"}, (*goverify).printSchema},
+	{[]string{"config", "resolve"}, (*goverify).printResolvedConfig},
+	{[]string{"baseline", "write"}, (*goverify).writeBaseline},
+}
+
+func (p *goverify) runSubcommand(args []string) error {
+	for _, sub := range subcommands {
+		if len(args) >= len(sub.words) && strings.Join(args[:len(sub.words)], " ") == strings.Join(sub.words, " ") {
+			return sub.run(p, args[len(sub.words):])
+		}
+	}
+	return fmt.Errorf("unknown command %s", strings.Join(args, " "))
+}
+
+func (p *goverify) warnf(format string, args ...interface{}) {
+	out := p.warnOutput
+	if out == nil {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "warning: "+format+"\n", args...)
+}
+
+// loadMacros fills in conf.Macros.  Macros come from, lowest precedence first, the built in macros, each of
+// conf.MacroFiles, then the config file itself.  A macro file may replace a built in macro, but two macro files
+// may not define the same macro.
 func (p *goverify) loadMacros(conf *config) error {
 	var err error
 	var macro config
 	if err = json.Unmarshal([]byte(macros), &macro); err != nil {
 		return err
 	}
-	if conf.Macros == nil {
-		conf.Macros = make(map[string]check)
+	allMacros := make(map[string]check, len(macro.Macros))
+	conf.macroSources = make(map[string]string, len(macro.Macros))
+	for k, v := range macro.Macros {
+		allMacros[k] = v
+		conf.macroSources[k] = "built in"
+	}
+	definedIn := make(map[string]string)
+	for _, macroFile := range conf.MacroFiles {
+		included, err := p.loadMacroFile(conf.rootPath, macroFile)
+		if err != nil {
+			return err
+		}
+		for k, v := range included {
+			if otherFile, exists := definedIn[k]; exists {
+				return fmt.Errorf("macro %s is defined in both %s and %s", k, otherFile, macroFile)
+			}
+			definedIn[k] = macroFile
+			allMacros[k] = v
+			conf.macroSources[k] = macroFile
+		}
+	}
+	for k, v := range conf.Macros {
+		allMacros[k] = v
+		conf.macroSources[k] = "config"
+	}
+	conf.macroDefs = make(map[string]check, len(allMacros))
+	for k, v := range allMacros {
+		conf.macroDefs[k] = v
+	}
+	if err = resolveExtends(allMacros); err != nil {
+		return err
 	}
 	conf.Checks = append(macro.Checks, conf.Checks...)
-	for k, v := range macro.Macros {
+	for k, v := range allMacros {
 		v.validateDecoded, err = p.getValidator(v)
 		if err != nil {
 			return err
 		}
-		conf.Macros[k] = v
+		allMacros[k] = v
 	}
+	conf.Macros = allMacros
 	return nil
 }
 
+func (p *goverify) loadMacroFile(rootPath string, macroFile string) (map[string]check, error) {
+	filename := macroFile
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(rootPath, filename)
+	}
+	fileContent, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var included config
+	if err = decodeConfigFile(filename, fileContent, &included); err != nil {
+		return nil, fmt.Errorf("unable to load macro file %s: %s", macroFile, err)
+	}
+	if err = validateMacroFile(filename, fileContent); err != nil {
+		return nil, err
+	}
+	return included.Macros, nil
+}
+
 func (p *goverify) loadConfig() (*config, error) {
 	var conf config
+	if p.configFile == "" {
+		configFile, err := findConfigFile(".", true)
+		if err != nil {
+			return nil, err
+		}
+		p.configFile = configFile
+	}
 	fileContent, err := ioutil.ReadFile(p.configFile)
 	if err != nil {
 		return nil, err
 	}
-	if err = json.Unmarshal(fileContent, &conf); err != nil {
+	if err = decodeConfigFile(p.configFile, fileContent, &conf); err != nil {
+		return nil, fmt.Errorf("unable to load config %s: %s", p.configFile, err)
+	}
+	fp, err := filepath.Abs(p.configFile)
+	if err != nil {
 		return nil, err
 	}
+	conf.rootPath = filepath.Dir(fp)
+	conf.configFile = p.configFile
+	conf.vars = newVarExpander(conf.rootPath)
 	if err = p.loadMacros(&conf); err != nil {
 		return nil, err
 	}
-	if conf.SimultaneousRuns == 0 {
-		conf.SimultaneousRuns = runtime.NumCPU()*2 + 1
+	if err = validateConfig(p.configFile, fileContent, &conf, nil); err != nil {
+		return nil, err
 	}
-	fp, err := filepath.Abs(p.configFile)
-	if err != nil {
+	if err = p.loadNestedConfigs(&conf); err != nil {
 		return nil, err
 	}
-	conf.rootPath = filepath.Dir(fp)
+	if err = applyProfile(&conf, p.profile); err != nil {
+		return nil, err
+	}
+	if conf.SimultaneousRuns == 0 {
+		conf.SimultaneousRuns = runtime.NumCPU()*2 + 1
+	}
 	return &conf, nil
 }
 
@@ -251,56 +445,188 @@ func (p *goverify) main() error {
 		p.cmdStdout = ioutil.Discard
 		p.cmdStderr = ioutil.Discard
 	}
+	if len(p.args) > 0 {
+		return p.runSubcommand(p.args)
+	}
 	conf, err := p.loadConfig()
 	if err != nil {
 		return err
 	}
-	for _, c := range conf.Checks {
-		c.validateDecoded, err = p.getValidator(c)
-		if err != nil {
+	report := &runReport{}
+	if report.baseline, err = loadBaseline(conf); err != nil {
+		return err
+	}
+	report.suppressions = newSuppressions(conf.rootPath, p.logger.Printf)
+	err = p.runChecks(conf, report)
+	report.warnings.print(p.out())
+	if scanErr := report.scanSuppressions(conf); scanErr != nil {
+		return scanErr
+	}
+	// An invalid suppression comment explains why a finding was not suppressed, so it is reported first
+	if suppressErr := report.suppressions.check(); suppressErr != nil {
+		return suppressErr
+	}
+	if err != nil {
+		return err
+	}
+	report.printStale(p.out())
+	report.printUnusedSuppressions(p.out())
+	return report.warnings.checkBudget(p.maxWarnings)
+}
+
+func (p *goverify) runChecks(conf *config, report *runReport) error {
+	var err error
+	for _, c := range scopedChecks(conf) {
+		if c.Disabled {
+			p.logger.Printf("Skipping disabled check %s in %s", nonEmptyStr(c.Name, c.Macro), nonEmptyStr(c.scopeDir, "."))
+			continue
+		}
+		if c, err = p.resolveCheck(conf, c); err != nil {
 			return err
 		}
-		if c.Macro != "" {
-			if err = p.copyFromMacro(conf, &c); err != nil {
-				return err
-			}
+		reason, err := p.skipReason(c)
+		if err != nil {
+			return err
 		}
-		if cover, ok := c.validateDecoded.(*coverageValidator); ok {
-			cover.IgnoreDir = conf.IgnoreDir
+		if reason != "" {
+			fmt.Fprintf(p.out(), "Skipped %s: %s\n", c.Name, reason)
+			continue
 		}
-		if err = p.checkStream(*conf, c); err != nil {
+		if err = p.checkStream(*conf, c, report); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// resolveCheck returns the check as it will run: merged with its macro, with parameters substituted and the
+// config's ignoreDir applied
+func (p *goverify) resolveCheck(conf *config, c check) (check, error) {
+	var err error
+	if c.Validator, err = conf.vars.expandJSON(c.Validator); err != nil {
+		return c, fmt.Errorf("check %s: %s", checkID(c), err)
+	}
+	c.validateDecoded, err = p.getValidator(c)
+	if err != nil {
+		return c, err
+	}
+	if c.Macro != "" {
+		if err = p.copyFromMacro(conf, &c); err != nil {
+			return c, err
+		}
+	}
+	c.flattenArgOps()
+	if err = c.applyParams(); err != nil {
+		return c, err
+	}
+	if err = c.expandVars(conf.vars); err != nil {
+		return c, fmt.Errorf("check %s: %s", c.Name, err)
+	}
+	c.rootPath = conf.rootPath
+	switch {
+	case c.Dir != "":
+		c.workDir = c.Dir
+		if !filepath.IsAbs(c.workDir) {
+			c.workDir = filepath.Join(conf.rootPath, filepath.FromSlash(c.Dir))
+		}
+	case c.Each == nil && c.scopeWorkDir != "":
+		// Each file is relative to the root config, so only checks that run once move to their nested config
+		c.workDir = c.scopeWorkDir
+	default:
+		c.workDir = conf.rootPath
+	}
+	if cover, ok := c.validateDecoded.(*coverageValidator); ok {
+		cover.IgnoreDir = conf.IgnoreDir
+		cover.excludeDirs = c.excludeScopes
+		cover.dir = c.workDir
+		cover.logf = p.logger.Printf
+	}
+	if c.Each != nil {
+		each := *c.Each
+		each.IgnoreDir = append(append([]string{}, each.IgnoreDir...), conf.IgnoreDir...)
+		c.Each = &each
+	}
+	return c, nil
+}
+
+// lookupMacro returns the macro called name, and the name it is defined under
+func lookupMacro(conf *config, name string) (string, check, bool) {
+	if existingMacro, exists := conf.Macros[name]; exists {
+		return name, existingMacro, true
+	}
+	// Older configs refer to legacy macros without their namespace
+	existingMacro, exists := conf.Macros[deprecatedNamespace+name]
+	return deprecatedNamespace + name, existingMacro, exists
+}
+
 func (p *goverify) copyFromMacro(conf *config, c *check) error {
-	existingMacro, exists := conf.Macros[c.Macro]
+	macroName, existingMacro, exists := lookupMacro(conf, c.Macro)
 	if !exists {
 		return fmt.Errorf("unable to find macro %s", c.Macro)
 	}
+	c.Macro = macroName
+	if existingMacro.Deprecated != "" {
+		p.warnf("macro %s is deprecated: %s", c.Macro, existingMacro.Deprecated)
+	}
 	p.logger.Printf("Loading properties for macro %s", c.Macro)
 	c.mergePropertiesFrom(existingMacro)
+	var err error
+	if existingMacro.Validator, err = conf.vars.expandJSON(existingMacro.Validator); err != nil {
+		return fmt.Errorf("macro %s: %s", c.Macro, err)
+	}
 	if c.validateDecoded == nil {
 		c.validateDecoded, _ = p.getValidator(existingMacro)
 		c.validateDecoded.MergePropertiesFrom(c.Validator)
 	}
-	if c.Macro == "go-cover" {
-		_ = c.validateDecoded.(*coverageValidator)
-	}
 	return nil
 }
 
+// goTools returns the tools listed by `go tool`.  This includes both the built in tools (vet, cover, ...)
+// and any tools declared with the `tool` directive of go.mod, which are listed by package path.
+func (p *goverify) goTools(c check) ([]string, error) {
+	cmd := c.command("go", "tool")
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := p.run(cmd); err != nil {
+		return nil, &checkResult{
+			checkName:   "go tool",
+			output:      output.String(),
+			originalErr: err,
+		}
+	}
+	return strings.Split(output.String(), "\n"), nil
+}
+
+// matchesModtool returns true if the listed tool is the module tool name.  Module tools may be referenced by
+// their full package path or by the last element of that path, the same way `go tool <name>` resolves them.
+func matchesModtool(listed string, name string) bool {
+	listed = strings.TrimSpace(listed)
+	return listed == name || path.Base(listed) == name
+}
+
 func (p *goverify) installToolIfNeeded(conf config, c check) error {
+	if c.Modtool != "" {
+		// Module tools are versioned by go.mod and built by `go tool` itself: there is nothing to install
+		tools, err := p.goTools(c)
+		if err != nil {
+			return err
+		}
+		for _, tool := range tools {
+			if matchesModtool(tool, c.Modtool) {
+				return nil
+			}
+		}
+		return fmt.Errorf("tool %s is not declared in go.mod: add it with `go get -tool`", c.Modtool)
+	}
 	toolFound := true
 	if c.Gotool != "" {
-		toolBytes, err := exec.Command("go", "tool").CombinedOutput()
+		tools, err := p.goTools(c)
 		if err != nil {
 			return err
 		}
 		toolFound = func() bool {
-			for _, tool := range strings.Split(string(toolBytes), "\n") {
+			for _, tool := range tools {
 				if tool == c.Gotool {
 					return true
 				}
@@ -312,27 +638,36 @@ func (p *goverify) installToolIfNeeded(conf config, c check) error {
 	if c.Install != nil && (err != nil || !toolFound) {
 		p.logger.Printf("Installing %s %s", c.Install.Cmd, c.Install.Args)
 		// Try to install
-		if err = exec.Command(c.Install.Cmd, c.Install.Args...).Run(); err != nil {
+		if err = p.run(c.command(c.Install.Cmd, c.Install.Args...)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *goverify) checkStream(conf config, c check) error {
+func (p *goverify) checkStream(conf config, c check, report *runReport) error {
 	var err error
-	if c.Each != nil {
-		c.Each.IgnoreDir = append(c.Each.IgnoreDir, conf.IgnoreDir...)
-	}
 	if err = p.installToolIfNeeded(conf, c); err != nil {
 		return err
 	}
+	if report.ran == nil {
+		report.ran = make(map[string][]check)
+	}
+	for _, id := range suppressionIDs(c) {
+		report.ran[id] = append(report.ran[id], c)
+	}
 	checkOutput := p.runCheck(conf, c)
 	var lastError error
 	for checkRes := range checkOutput {
+		checkRes = report.applyBaseline(c, report.applySuppressions(c, applySeverity(c.Severity, checkRes)))
+		report.warnings.add(c.Name, checkRes.findings)
 		if checkRes.originalErr != nil {
 			lastError = checkRes.originalErr
-			fmt.Printf("%s\n", strings.TrimSpace(checkRes.output))
+			fmt.Fprintf(p.out(), "%s\n", strings.TrimSpace(checkRes.output))
+			continue
+		}
+		for _, f := range checkRes.findings {
+			fmt.Fprintf(p.out(), "%s: %s\n", c.Name, f)
 		}
 	}
 	if lastError != nil {
@@ -341,29 +676,43 @@ func (p *goverify) checkStream(conf config, c check) error {
 	return nil
 }
 
-func (p *goverify) getValidator(c check) (cmdValidator, error) {
-	if c.Validator == nil {
+// validatorTypes creates the validator for each validate.type
+var validatorTypes = map[string]func() cmdValidator{
+	"": func() cmdValidator {
 		return &emptyValidator{
 			IgnoreMsg: []string{},
-		}, nil
+		}
+	},
+	"cover": func() cmdValidator {
+		return &coverageValidator{}
+	},
+	"gotest": func() cmdValidator {
+		return &testValidator{}
+	},
+	"regex": func() cmdValidator {
+		return &regexValidator{}
+	},
+	"returncode": func() cmdValidator {
+		return &emptyValidator{
+			IgnoreMsg:       []string{},
+			IgnoreAllOutput: true,
+		}
+	},
+}
+
+func (p *goverify) getValidator(c check) (cmdValidator, error) {
+	if c.Validator == nil {
+		return validatorTypes[""](), nil
 	}
-	var dest cmdValidator
 	var v validator
 	if err := json.Unmarshal(c.Validator, &v); err != nil {
 		return nil, err
 	}
-	if v.Type == "cover" {
-		dest = &coverageValidator{}
-	} else if v.Type == "returncode" {
-		dest = &emptyValidator{
-			IgnoreMsg:       []string{},
-			IgnoreAllOutput: true,
-		}
-	} else {
-		dest = &emptyValidator{
-			IgnoreMsg: []string{},
-		}
+	newValidator, exists := validatorTypes[v.Type]
+	if !exists {
+		return nil, fmt.Errorf("unknown validate type %s", v.Type)
 	}
+	dest := newValidator()
 	if err := json.Unmarshal(c.Validator, dest); err != nil {
 		return nil, err
 	}
@@ -418,71 +767,6 @@ func (c *emptyValidator) Check(stdout *bytes.Buffer, stderr *bytes.Buffer) error
 	return nil
 }
 
-type coverageValidator struct {
-	validator
-	RequiredCoverage float64  `json:"coverage"`
-	IgnoreDir        []string `json:"ignoreDir"`
-}
-
-type coverageError struct {
-	seen     float64
-	required float64
-}
-
-func (c *coverageError) Error() string {
-	return fmt.Sprintf("Coverage %f less than required %f", c.seen, c.required)
-}
-
-func (c *coverageValidator) MergePropertiesFrom(val json.RawMessage) {
-	if val == nil {
-		return
-	}
-	var other coverageValidator
-	if err := json.Unmarshal(val, &other); err != nil {
-		return
-	}
-	if other.RequiredCoverage != 0 {
-		c.RequiredCoverage = other.RequiredCoverage
-	}
-	c.IgnoreDir = nonEmptyStrArr(other.IgnoreDir, c.IgnoreDir)
-}
-
-func (c *coverageValidator) Check(stdout *bytes.Buffer, stderr *bytes.Buffer) error {
-	pattern := regexp.MustCompile(`coverage: ([0-9\.]+)% of statements`)
-	for _, coverout := range strings.Split(stdout.String(), "\n") {
-		if coverout == "" {
-			continue
-		}
-		matchPercent, err := func() (float64, error) {
-			if strings.Contains(coverout, "[no test files]") {
-				return 0.0, nil
-			}
-			matches := pattern.FindStringSubmatch(coverout)
-			if matches == nil {
-				return 0.0, fmt.Errorf("unable to find match in string: %s", coverout)
-			}
-			return strconv.ParseFloat(matches[1], 64)
-		}()
-		if err != nil {
-			return err
-		}
-		parts := strings.Split(coverout, "\t")
-		if len(parts) > 1 {
-			testPath := parts[1]
-			if containsName(testPath, c.IgnoreDir) {
-				continue
-			}
-		}
-		if matchPercent+.009 <= c.RequiredCoverage {
-			return &coverageError{
-				seen:     matchPercent,
-				required: c.RequiredCoverage,
-			}
-		}
-	}
-	return nil
-}
-
 func (p *goverify) runCheck(conf config, c check) chan checkResult {
 	p.logger.Printf("Running check `%s`", c.String())
 	var params []string
@@ -538,8 +822,43 @@ func run(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
-func hasGodepDirectory() bool {
-	_, err := os.Stat("Godeps")
+// command returns a command that runs in the check's directory and environment
+func (c *check) command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = c.workDir
+	if len(c.Env) > 0 {
+		keys := make([]string, 0, len(c.Env))
+		for k := range c.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		cmd.Env = os.Environ()
+		for _, k := range keys {
+			cmd.Env = append(cmd.Env, k+"="+c.Env[k])
+		}
+	}
+	return cmd
+}
+
+func mergeEnv(e1, e2 map[string]string) map[string]string {
+	if len(e1) == 0 {
+		return e2
+	}
+	if len(e2) == 0 {
+		return e1
+	}
+	ret := make(map[string]string, len(e1)+len(e2))
+	for k, v := range e2 {
+		ret[k] = v
+	}
+	for k, v := range e1 {
+		ret[k] = v
+	}
+	return ret
+}
+
+func hasGodepDirectory(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "Godeps"))
 	if err == nil {
 		return true
 	}
@@ -550,38 +869,123 @@ func hasGodepDirectory() bool {
 }
 
 func (p *goverify) innerCheckIteration(conf config, c check, param string) checkResult {
-	args := func() []string {
-		if p.fix && c.Fix != nil {
-			return append(make([]string, 0, len(c.Fix.Args)), c.Fix.Args...)
+	toRun := c.Check
+	if p.fix && c.Fix != nil {
+		toRun = c.Fix
+	}
+	res := p.runCheckCmd(c, toRun, param)
+	for _, then := range c.Then {
+		if res.originalErr != nil {
+			break
 		}
-		return append(make([]string, 0, len(c.Check.Args)), c.Check.Args...)
-	}()
+		res = p.runCheckCmd(c, then, param)
+	}
+	return res
+}
+
+// commandLine returns the command and args that run toRun for param
+func (c *check) commandLine(toRun *checkCmd, param string) (string, []string) {
+	args := append(make([]string, 0, len(toRun.Args)), toRun.Args...)
 	for i := range args {
 		if args[i] == "$1" {
 			args[i] = param
 		}
+		args[i] = strings.Replace(args[i], fileVar, param, -1)
 	}
-	var cmdToRun string
-	if c.Godep != nil && *c.Godep && hasGodepDirectory() {
-		cmdToRun = "godep"
-		args = append([]string{"go"}, args...)
-	} else {
-		cmdToRun = c.Cmd
+	if toRun.Cmd != "" {
+		return toRun.Cmd, args
+	}
+	if c.Modtool != "" {
+		return "go", append([]string{"tool", c.Modtool}, args...)
+	}
+	if c.Godep != nil && *c.Godep && hasGodepDirectory(c.workDir) {
+		return "godep", append([]string{"go"}, args...)
+	}
+	return c.Cmd, args
+}
+
+func (p *goverify) runCheckCmd(c check, toRun *checkCmd, param string) checkResult {
+	cmdToRun, args := c.commandLine(toRun, param)
+	args, err := p.scopePackages(c, args)
+	if err != nil {
+		return checkResult{
+			originalErr: err,
+		}
+	}
+	if args == nil {
+		p.logger.Printf("No packages left for %s outside of nested configs", c.Name)
+		return checkResult{}
+	}
+	profile, err := replaceCoverProfile(args)
+	if err != nil {
+		return checkResult{
+			originalErr: err,
+		}
+	}
+	if profile != "" {
+		defer func() {
+			if err := os.RemoveAll(filepath.Dir(profile)); err != nil {
+				p.logger.Printf("Unable to remove coverprofile %s: %s", profile, err)
+			}
+		}()
 	}
 	p.logger.Printf("Running command %s %s %v\n", cmdToRun, args, &c)
-	cmd := exec.Command(cmdToRun, args...)
+	cmd := c.command(cmdToRun, args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = io.MultiWriter(&stdout, p.cmdStdout)
 	cmd.Stderr = io.MultiWriter(&stderr, p.cmdStderr)
-	err := p.run(cmd)
+	err = p.run(cmd)
+	if tv, ok := c.validateDecoded.(*testValidator); ok {
+		return p.checkTests(c, tv, toRun, param, &stdout, &stderr, err)
+	}
 	output := stdout.String() + stderr.String()
+	if fv, ok := c.validateDecoded.(findingsValidator); ok {
+		findings, findErr := fv.Findings(&stdout, &stderr)
+		if findErr != nil {
+			return checkResult{
+				originalErr: findErr,
+				output:      output,
+			}
+		}
+		// Many tools exit with an error when they find anything, so the exit code only matters without findings
+		if len(findings) > 0 || err == nil {
+			return checkResult{
+				originalErr: checkFindings(findings),
+				output:      formatFindings(findings),
+				findings:    findings,
+			}
+		}
+	}
 	if err != nil {
 		return checkResult{
 			originalErr: err,
 			output:      output,
 		}
 	}
+	if pv, ok := c.validateDecoded.(profileValidator); ok && profile != "" {
+		if cover, ok := pv.(*coverageValidator); ok {
+			if pv, err = p.coverageInputs(c, cover, toRun, param); err != nil {
+				return checkResult{
+					originalErr: err,
+					output:      output,
+				}
+			}
+		}
+		content, err := ioutil.ReadFile(profile)
+		if err == nil {
+			err = pv.CheckProfile(content, &stdout, &stderr)
+		}
+		if err != nil {
+			return checkResult{
+				originalErr: err,
+				output:      output,
+			}
+		}
+		return checkResult{
+			output: output,
+		}
+	}
 	if err = c.validateDecoded.Check(&stdout, &stderr); err != nil {
 		return checkResult{
 			originalErr: err,
@@ -594,8 +998,7 @@ func (p *goverify) innerCheckIteration(conf config, c check, param string) check
 }
 
 func (p *goverify) getParams(conf config, c check) ([]string, error) {
-	cmd := exec.Command(c.Each.Cmd, c.Each.Args...)
-	cmd.Dir = p.rootDir
+	cmd := c.command(c.Each.Cmd, c.Each.Args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -607,10 +1010,19 @@ func (p *goverify) getParams(conf config, c check) ([]string, error) {
 		}
 	}
 	files := []string{}
+	seenDirs := make(map[string]bool)
 	for _, file := range strings.Split(stdout.String(), "\n") {
-		if !c.Each.filteredFilename(file) {
-			files = append(files, file)
+		if c.Each.filteredFilename(file) || !c.inScope(c.rootRelative(file)) {
+			continue
+		}
+		if c.Each.Dirs {
+			file = path.Dir(file)
+			if seenDirs[file] {
+				continue
+			}
+			seenDirs[file] = true
 		}
+		files = append(files, file)
 	}
 	return files, nil
 }
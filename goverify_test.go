@@ -2,9 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -38,6 +43,76 @@ func TestCoverageValidator(t *testing.T) {
 	errorSeen(t, c.Check(stdout, stderr))
 }
 
+func TestCoverageThresholds(t *testing.T) {
+	c := coverageValidator{
+		RequiredCoverage: 60,
+		Packages: map[string]float64{
+			"internal/legacy/...":    20,
+			"internal/legacy/strict": 90,
+		},
+	}
+	output := `ok  	github.com/a/repo	0.052s	coverage: 80.0% of statements
+ok  	github.com/a/repo/internal/legacy	0.052s	coverage: 25.0% of statements
+ok  	github.com/a/repo/internal/legacy/old	0.052s	coverage: 21.0% of statements
+ok  	github.com/a/repo/internal/legacy/strict	0.052s	coverage: 95.0% of statements
+`
+	noError(t, c.Check(bytes.NewBufferString(output), &bytes.Buffer{}))
+	// The output of go test has no statement counts to weigh the total by
+	c.TotalCoverage = 50
+	if err := c.Check(bytes.NewBufferString(output), &bytes.Buffer{}); err == nil || !strings.HasPrefix(err.Error(), "totalCoverage needs statement counts") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.TotalCoverage = 0
+	c.Packages["internal/legacy/old"] = 30
+	if err := c.Check(bytes.NewBufferString(output), &bytes.Buffer{}); err == nil || err.Error() != "Coverage 21.000000 of github.com/a/repo/internal/legacy/old less than required 30.000000" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestCoverProfile(t *testing.T) {
+	// With -coverpkg, each test binary lists the blocks of every package
+	profile := `mode: atomic
+github.com/a/repo/a.go:1.1,3.2 3 1
+github.com/a/repo/a.go:4.1,5.2 1 0
+github.com/a/repo/lib/b.go:1.1,3.2 6 0
+github.com/a/repo/a.go:1.1,3.2 3 0
+github.com/a/repo/a.go:4.1,5.2 1 0
+github.com/a/repo/lib/b.go:1.1,3.2 6 2
+github.com/a/repo/lib/c.go:1.1,3.2 2 0
+`
+	blocks, err := parseCoverProfile([]byte(profile))
+	noError(t, err)
+	summary := summarizeCoverage(blocks)
+	if summary.total != (coverageCounts{statements: 12, covered: 9}) {
+		t.Errorf("Unexpected total %v", summary.total)
+	}
+	if summary.packages["github.com/a/repo/lib"].percent() != 75 || summary.files["github.com/a/repo/lib/c.go"].percent() != 0 {
+		t.Errorf("Unexpected coverage %v", summary)
+	}
+	c := coverageValidator{
+		RequiredCoverage: 75,
+		TotalCoverage:    70,
+	}
+	noError(t, c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{}))
+	c.RequiredCoverage = 80
+	c.TotalCoverage = 80
+	err = c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil || err.Error() != `Coverage 75.000000 of github.com/a/repo less than required 80.000000
+  a.go: 75.0%
+Coverage 75.000000 of github.com/a/repo/lib less than required 80.000000
+  c.go: 0.0%
+Total coverage 75.000000 less than required 80.000000` {
+		t.Errorf("Unexpected error %v", err)
+	}
+	c.IgnoreDir = []string{"lib"}
+	c.RequiredCoverage = 70
+	c.TotalCoverage = 70
+	noError(t, c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{}))
+	if _, err = parseCoverProfile([]byte("mode: set\nnot a block\n")); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
+
 var t1 = `{
   "checks": [
     {
@@ -63,6 +138,16 @@ func panicIfNotNil(i interface{}) {
 	}
 }
 
+// tempConfig writes content to a goverify.json in a new directory, so that no unrelated config is loaded as a
+// nested config
+func tempConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", t.Name())
+	noError(t, err)
+	filename := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(filename, []byte(content), os.FileMode(0600)))
+	return filename, func() { panicIfNotNil(os.RemoveAll(dir)) }
+}
+
 func panicIfNotNil2(_ interface{}, i interface{}) {
 	if i != nil {
 		panic(i)
@@ -70,12 +155,8 @@ func panicIfNotNil2(_ interface{}, i interface{}) {
 }
 
 func TestSimpleCover(t *testing.T) {
-	fout, err := ioutil.TempFile("", "TestSimpleCover")
-	noError(t, err)
-	filename := fout.Name()
-	defer func() { panicIfNotNil(os.Remove(filename)) }()
-	panicIfNotNil(fout.Close())
-	noError(t, ioutil.WriteFile(filename, []byte(t1), os.FileMode(0600)))
+	filename, cleanup := tempConfig(t, t1)
+	defer cleanup()
 	m := &goverify{
 		run: func(cmd *exec.Cmd) error {
 			if strings.HasSuffix(cmd.Path, "git") {
@@ -103,3 +184,1219 @@ func TestEachFileLister(t *testing.T) {
 		panic("Expect not to filter abcde")
 	}
 }
+
+var t2 = `{
+  "checks": [
+    {
+      "name": "staticcheck",
+      "modtool": "staticcheck",
+      "check": {
+        "args": ["./..."]
+      }
+    }
+  ]
+}`
+
+func TestModtool(t *testing.T) {
+	filename, cleanup := tempConfig(t, t2)
+	defer cleanup()
+	var ran []string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if len(cmd.Args) == 2 && cmd.Args[1] == "tool" {
+				panicIfNotNil2(cmd.Stdout.Write([]byte("cover\nvet\nhonnef.co/go/tools/cmd/staticcheck\n")))
+				return nil
+			}
+			ran = cmd.Args
+			return nil
+		},
+		configFile: filename,
+	}
+	noError(t, m.main())
+	if strings.Join(ran, " ") != "go tool staticcheck ./..." {
+		t.Errorf("Unexpected command %s", ran)
+	}
+
+	m.run = func(cmd *exec.Cmd) error {
+		panicIfNotNil2(cmd.Stdout.Write([]byte("cover\nvet\n")))
+		return nil
+	}
+	errorSeen(t, m.main())
+}
+
+func TestTestValidator(t *testing.T) {
+	c := testValidator{}
+	stderr := new(bytes.Buffer)
+	stdout := bytes.NewBufferString(`{"Action":"run","Package":"a","Test":"TestA"}
+{"Action":"pass","Package":"a","Test":"TestA","Elapsed":0.01}
+{"Action":"pass","Package":"a","Elapsed":0.02}
+`)
+	noError(t, c.Check(stdout, stderr))
+
+	stdout = bytes.NewBufferString(`{"Action":"run","Package":"a","Test":"TestA"}
+{"Action":"fail","Package":"a","Test":"TestA","Elapsed":0.01}
+{"Action":"fail","Package":"a","Elapsed":0.02}
+`)
+	err := c.Check(stdout, stderr)
+	errorSeen(t, err)
+	if err.Error() != "1 failed: a.TestA" {
+		t.Errorf("Unexpected error %s", err)
+	}
+}
+
+func TestTestRetries(t *testing.T) {
+	filename, cleanup := tempConfig(t, `{"checks": [{"macro": "go-test", "validate": {"retries": 2}}]}`)
+	defer cleanup()
+	events := `{"Action":"run","Package":"a","Test":"TestA"}
+{"Action":"output","Package":"a","Test":"TestA","Output":"    a_test.go:5: timed out\n"}
+{"Action":"fail","Package":"a","Test":"TestA","Elapsed":0.5}
+{"Action":"run","Package":"a","Test":"TestB"}
+{"Action":"run","Package":"a","Test":"TestB/sub"}
+{"Action":"output","Package":"a","Test":"TestB/sub","Output":"    a_test.go:9: got 1\n"}
+{"Action":"fail","Package":"a","Test":"TestB/sub","Elapsed":0.01}
+{"Action":"fail","Package":"a","Test":"TestB","Elapsed":0.01}
+{"Action":"run","Package":"a","Test":"TestC"}
+{"Action":"pass","Package":"a","Test":"TestC","Elapsed":0.01}
+{"Action":"fail","Package":"a","Elapsed":0.6}
+`
+	var retries []string
+	var output bytes.Buffer
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			ranEvents := events
+			if cmd.Args[len(cmd.Args)-2] == "-run" {
+				// TestA passes when retried
+				retries = append(retries, cmd.Args[len(cmd.Args)-1])
+				ranEvents = strings.Replace(ranEvents, `"fail","Package":"a","Test":"TestA"`, `"pass","Package":"a","Test":"TestA"`, 1)
+			}
+			panicIfNotNil2(cmd.Stdout.Write([]byte(ranEvents)))
+			return errors.New("exit status 1")
+		},
+		configFile:  filename,
+		output:      &output,
+		maxWarnings: -1,
+	}
+	err := m.main()
+	if err == nil || !strings.Contains(err.Error(), "2 failed: a.TestB, a.TestB/sub") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Join(retries, " ") != "^(TestA|TestB)$ ^(TestB)$" {
+		t.Errorf("Unexpected retries %v", retries)
+	}
+	for _, expected := range []string{"a.TestA is flaky: it passed on retry 1\n", "--- FAIL: a.TestB/sub (0.01s)\n    a_test.go:9: got 1\n"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in output %s", expected, output.String())
+		}
+	}
+	res := m.checkTests(check{}, &testValidator{}, nil, "", bytes.NewBufferString(events), &bytes.Buffer{}, errors.New("exit status 1"))
+	if res.output != "--- FAIL: a.TestA (0.50s)\n    a_test.go:5: timed out\n--- FAIL: a.TestB (0.01s)\n--- FAIL: a.TestB/sub (0.01s)\n    a_test.go:9: got 1\n" {
+		t.Errorf("Unexpected output %q", res.output)
+	}
+
+	// Only flaky tests pass, with a warning
+	events = strings.Replace(strings.Replace(events, `"fail","Package":"a","Test":"TestB`, `"pass","Package":"a","Test":"TestB`, 2), `"fail","Package":"a","Elapsed"`, `"pass","Package":"a","Elapsed"`, 1)
+	output.Reset()
+	noError(t, m.main())
+	if !strings.Contains(output.String(), "go test: warning: a.TestA is flaky: it passed on retry 1") {
+		t.Errorf("Unexpected output %s", output.String())
+	}
+}
+
+func TestDeprecatedMacro(t *testing.T) {
+	var warnings bytes.Buffer
+	m := &goverify{
+		logger:     log.New(ioutil.Discard, "", 0),
+		warnOutput: &warnings,
+	}
+	conf := &config{}
+	noError(t, m.loadMacros(conf))
+	c := check{
+		Macro: "golint",
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	if c.Macro != "deprecated/golint" || c.Cmd != "golint" {
+		t.Errorf("Expect legacy golint macro, got %s", &c)
+	}
+	if !strings.Contains(warnings.String(), "golint is deprecated") {
+		t.Errorf("Expect deprecation warning, got %s", warnings.String())
+	}
+}
+
+func TestModTidyEachModule(t *testing.T) {
+	filename, cleanup := tempConfig(t, `{"checks": [{"macro": "mod-tidy"}], "simultaneousRuns": 1}`)
+	defer cleanup()
+	var ran []string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if strings.HasSuffix(cmd.Path, "git") {
+				panicIfNotNil2(cmd.Stdout.Write([]byte("go.mod\nsub/go.mod\n")))
+				return nil
+			}
+			ran = append(ran, strings.Join(cmd.Args[1:], " "))
+			return nil
+		},
+		configFile: filename,
+	}
+	noError(t, m.main())
+	expected := "-C . mod tidy -diff|-C . mod verify|-C sub mod tidy -diff|-C sub mod verify"
+	if strings.Join(ran, "|") != expected {
+		t.Errorf("Unexpected commands %s", ran)
+	}
+
+	ran = nil
+	m.fix = true
+	noError(t, m.main())
+	expected = "-C . mod tidy|-C . mod verify|-C sub mod tidy|-C sub mod verify"
+	if strings.Join(ran, "|") != expected {
+		t.Errorf("Unexpected fix commands %s", ran)
+	}
+}
+
+func TestMacroFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMacroFiles")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"macros": {"shared": {"cmd": "fromA"}, "gofmt": {"cmd": "myfmt"}}}`), os.FileMode(0600)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"macros": {"other": {"cmd": "fromB"}}}`), os.FileMode(0600)))
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{"macroFiles": ["a.json", "b.json"], "macros": {"other": {"cmd": "fromConfig"}}}`), os.FileMode(0600)))
+	m := &goverify{
+		configFile: configFile,
+	}
+	conf, err := m.loadConfig()
+	noError(t, err)
+	if conf.Macros["shared"].Cmd != "fromA" || conf.Macros["gofmt"].Cmd != "myfmt" || conf.Macros["other"].Cmd != "fromConfig" {
+		t.Errorf("Unexpected macro precedence %v", conf.Macros)
+	}
+	if conf.Macros["goimport"].Cmd != "goimports" {
+		t.Errorf("Expect built in macros to still load")
+	}
+
+	noError(t, ioutil.WriteFile(configFile, []byte(`{"macroFiles": ["a.json", "a.json"]}`), os.FileMode(0600)))
+	_, err = m.loadConfig()
+	errorSeen(t, err)
+}
+
+func TestMacroParams(t *testing.T) {
+	m := &goverify{
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	conf := &config{}
+	noError(t, m.loadMacros(conf))
+	c := check{
+		Macro: "gocyclo",
+		With:  map[string]interface{}{"over": 15.0},
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	noError(t, c.applyParams())
+	if strings.Join(c.Check.Args, " ") != "-over 15 $1" {
+		t.Errorf("Unexpected args %s", c.Check.Args)
+	}
+	if conf.Macros["gocyclo"].Check.Args[1] != "{{.over}}" {
+		t.Errorf("Expect macro to be unchanged")
+	}
+
+	// Large numbers are not printed in exponent form
+	c = check{
+		Macro: "gocyclo",
+		With:  map[string]interface{}{"over": 2000000.0},
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	noError(t, c.applyParams())
+	if strings.Join(c.Check.Args, " ") != "-over 2000000 $1" {
+		t.Errorf("Unexpected args %s", c.Check.Args)
+	}
+
+	c = check{
+		Macro: "gocyclo",
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	noError(t, c.applyParams())
+	if strings.Join(c.Check.Args, " ") != "-over 10 $1" {
+		t.Errorf("Unexpected default args %s", c.Check.Args)
+	}
+
+	c = check{
+		Macro: "gocyclo",
+		With:  map[string]interface{}{"over": "high"},
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	errorSeen(t, c.applyParams())
+
+	c = check{
+		Macro: "gocyclo",
+		With:  map[string]interface{}{"under": 3.0},
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	errorSeen(t, c.applyParams())
+}
+
+func TestMacroExtends(t *testing.T) {
+	m := &goverify{
+		logger: log.New(ioutil.Discard, "", 0),
+	}
+	conf := &config{
+		Macros: map[string]check{
+			"race-cover": {
+				Extends: "go-cover",
+				Name:    "race coverage",
+				Check: &checkCmd{
+					argOps: argOps{
+						ArgsRemove:  []string{"-race"},
+						ArgsPrepend: []string{"-v"},
+					},
+				},
+				Validator: json.RawMessage(`{"coverage": 50}`),
+			},
+			"race-cover-count": {
+				Extends: "race-cover",
+			},
+		},
+	}
+	noError(t, m.loadMacros(conf))
+	c := check{
+		Macro: "race-cover-count",
+		Check: &checkCmd{
+			argOps: argOps{
+				ArgsAppend: []string{"-count=1"},
+			},
+		},
+	}
+	noError(t, m.copyFromMacro(conf, &c))
+	if c.Name != "race coverage" || c.Cmd != "go" {
+		t.Errorf("Expect inherited properties, got %s", &c)
+	}
+	if strings.Join(c.Check.Args, " ") != "-v test -covermode atomic -coverprofile ${coverprofile} -parallel=8 -timeout {{.timeout}} -cpu 4 ./... -count=1" {
+		t.Errorf("Unexpected args %s", c.Check.Args)
+	}
+	if cover := c.validateDecoded.(*coverageValidator); cover.RequiredCoverage != 50 {
+		t.Errorf("Expect merged coverage validator, got %v", cover)
+	}
+
+	conf = &config{
+		Macros: map[string]check{
+			"a": {Extends: "b"},
+			"b": {Extends: "a"},
+		},
+	}
+	errorSeen(t, m.loadMacros(conf))
+}
+
+func TestConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestConfigFormats")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	files := map[string]string{
+		"goverify.yaml": `
+# gocyclo is loose while we clean up
+checks:
+  - macro: gocyclo
+    with:
+      over: 15
+  - macro: go-cover
+    validate:
+      coverage: 40
+simultaneousRuns: 2
+`,
+		"goverify.toml": `
+# gocyclo is loose while we clean up
+simultaneousRuns = 2
+
+[[checks]]
+macro = "gocyclo"
+with = { over = 15 }
+
+[[checks]]
+macro = "go-cover"
+validate = { coverage = 40 }
+`,
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		noError(t, ioutil.WriteFile(filename, []byte(content), os.FileMode(0600)))
+		m := &goverify{
+			configFile: filename,
+		}
+		conf, err := m.loadConfig()
+		noError(t, err)
+		if len(conf.Checks) != 2 || conf.SimultaneousRuns != 2 || conf.Checks[0].With["over"] != 15.0 || conf.Checks[0].Macro != "gocyclo" {
+			t.Errorf("Unexpected config from %s: %v", name, conf)
+		}
+		if string(conf.Checks[1].Validator) != `{"coverage":40}` {
+			t.Errorf("Unexpected validator from %s: %s", name, conf.Checks[1].Validator)
+		}
+	}
+	_, err = findConfigFile(dir, true)
+	errorSeen(t, err)
+	noError(t, os.Remove(filepath.Join(dir, "goverify.toml")))
+	found, err := findConfigFile(dir, true)
+	noError(t, err)
+	if found != filepath.Join(dir, "goverify.yaml") {
+		t.Errorf("Unexpected config file %s", found)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestValidateConfig")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	files := map[string]string{
+		"goverify.json": `{
+  "ignoredir": ["vendor"],
+  "checks": [
+    {"macro": "gocyclo"},
+    {"macro": "nosuchmacro"},
+    {"name": "no command", "check": {"args": ["x"]}},
+    {"macro": "go-cover", "validate": {"coverage": 40, "covrage": 50}},
+    {"cmd": "true", "check": {"args": ["x"]}, "validate": {"type": "nosuchtype"}}
+  ]
+}`,
+		"goverify.yaml": `ignoredir: [vendor]
+checks:
+  - macro: gocyclo
+  - macro: nosuchmacro
+  - name: no command
+    check: {args: [x]}
+  - macro: go-cover
+    validate: {coverage: 40, covrage: 50}
+  - cmd: "true"
+    check: {args: [x]}
+    validate: {type: nosuchtype}
+`,
+		"goverify.toml": `ignoredir = ["vendor"]
+
+[[checks]]
+macro = "gocyclo"
+
+[[checks]]
+macro = "nosuchmacro"
+
+[[checks]]
+name = "no command"
+check = { args = ["x"] }
+
+[[checks]]
+macro = "go-cover"
+validate = { coverage = 40, covrage = 50 }
+
+[[checks]]
+cmd = "true"
+check = { args = ["x"] }
+validate = { type = "nosuchtype" }
+`,
+	}
+	expected := map[string][]string{
+		"goverify.json": {
+			`:2:3: config: unknown field "ignoredir" (did you mean "ignoreDir"?)`,
+			`:5:6: checks[1]: unknown macro nosuchmacro`,
+			`:6:5: checks[2]: check has no cmd`,
+			`:7:56: checks[3].validate: unknown field "covrage"`,
+			`:8:60: checks[4].validate: unknown validate type nosuchtype: expected one of cover, gotest, regex, returncode`,
+		},
+		"goverify.yaml": {
+			`:1:1: config: unknown field "ignoredir" (did you mean "ignoreDir"?)`,
+			`:4:5: checks[1]: unknown macro nosuchmacro`,
+			`:5:5: checks[2]: check has no cmd`,
+			`:8:30: checks[3].validate: unknown field "covrage"`,
+			`:11:16: checks[4].validate: unknown validate type nosuchtype: expected one of cover, gotest, regex, returncode`,
+		},
+		"goverify.toml": {
+			`:1:1: config: unknown field "ignoredir" (did you mean "ignoreDir"?)`,
+			`:7:1: checks[1]: unknown macro nosuchmacro`,
+			`:9:3: checks[2]: check has no cmd`,
+			`:15:29: checks[3].validate: unknown field "covrage"`,
+			`:20:14: checks[4].validate: unknown validate type nosuchtype: expected one of cover, gotest, regex, returncode`,
+		},
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		noError(t, ioutil.WriteFile(filename, []byte(content), os.FileMode(0600)))
+		m := &goverify{
+			configFile: filename,
+		}
+		_, err := m.loadConfig()
+		errorSeen(t, err)
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(expected[name]) {
+			t.Errorf("Unexpected errors for %s:\n%s", name, err)
+			continue
+		}
+		for i, line := range lines {
+			if line != filename+expected[name][i] {
+				t.Errorf("Unexpected error for %s: %s, expected %s", name, line, expected[name][i])
+			}
+		}
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	var out bytes.Buffer
+	m := &goverify{
+		args:   []string{"config", "schema"},
+		output: &out,
+	}
+	noError(t, m.main())
+	committed, err := ioutil.ReadFile("goverify.schema.json")
+	noError(t, err)
+	if out.String() != string(committed) {
+		t.Errorf("goverify.schema.json is out of date: regenerate it with `goverify config schema > goverify.schema.json`")
+	}
+	errorSeen(t, (&goverify{args: []string{"config", "nosuchcommand"}}).main())
+}
+
+func TestOwnConfigIsValid(t *testing.T) {
+	m := &goverify{
+		configFile: "goverify.json",
+	}
+	_, err := m.loadConfig()
+	noError(t, err)
+}
+
+func TestResolveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestResolveConfig")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "ignoreDir": ["vendor"],
+  "checks": [
+    {"macro": "go-cover", "with": {"timeout": "10s"}, "check": {"argsAppend": ["-count=1"]}, "validate": {"coverage": 40}},
+    {"macro": "gocyclo"}
+  ]
+}`), os.FileMode(0600)))
+	var out bytes.Buffer
+	m := &goverify{
+		configFile: configFile,
+		args:       []string{"config", "resolve"},
+		output:     &out,
+	}
+	noError(t, m.main())
+	var resolved resolvedConfig
+	noError(t, json.Unmarshal(out.Bytes(), &resolved))
+	cover := resolved.Checks[0]
+	if cover.Check != "go test -covermode atomic -coverprofile ${coverprofile} -race -parallel=8 -timeout 10s -cpu 4 ./... -count=1" {
+		t.Errorf("Unexpected check command line %s", cover.Check)
+	}
+	expectedSources := map[string]string{
+		"check":              "macro go-cover (built in), edited by " + configFile + " checks[0]",
+		"name":               "macro go-cover (built in)",
+		"params.timeout":     configFile + " checks[0]",
+		"validate.coverage":  configFile + " checks[0]",
+		"validate.type":      "macro go-cover (built in)",
+		"validate.ignoreDir": "config ignoreDir",
+	}
+	for k, v := range expectedSources {
+		if cover.Sources[k] != v {
+			t.Errorf("Unexpected source of %s: %s", k, cover.Sources[k])
+		}
+	}
+	if cover.Validate["coverage"] != 40.0 {
+		t.Errorf("Unexpected validator %v", cover.Validate)
+	}
+	cyclo := resolved.Checks[1]
+	if strings.Join(cyclo.IgnoreDir, ",") != "vendor" || cyclo.Sources["each.ignoreDir"] != "config ignoreDir" {
+		t.Errorf("Unexpected ignoreDir %s from %s", cyclo.IgnoreDir, cyclo.Sources["each.ignoreDir"])
+	}
+
+	out.Reset()
+	m.args = []string{"config", "resolve", "-format", "yaml"}
+	noError(t, m.main())
+	if !strings.Contains(out.String(), "name: code coverage") {
+		t.Errorf("Unexpected yaml output %s", out.String())
+	}
+}
+
+func TestNestedConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestNestedConfigs")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, os.MkdirAll(filepath.Join(dir, "legacy", "old"), os.FileMode(0700)))
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "simultaneousRuns": 1,
+  "checks": [
+    {"macro": "gocyclo"},
+    {"macro": "go-cover", "validate": {"coverage": 80}}
+  ]
+}`), os.FileMode(0600)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "legacy", "goverify.yaml"), []byte(`checks:
+  - macro: gocyclo
+    with: {over: 30}
+  - macro: go-cover
+    validate: {coverage: 20}
+`), os.FileMode(0600)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "legacy", "old", "goverify.json"), []byte(`{
+  "checks": [
+    {"macro": "gocyclo", "disabled": true},
+    {"name": "old lint", "cmd": "oldlint", "check": {"args": ["./..."]}}
+  ]
+}`), os.FileMode(0600)))
+	// Configs in testdata and in other modules are not nested configs
+	foreign := `{"checks": [{"name": "foreign", "cmd": "foreign", "check": {"args": ["./..."]}}]}`
+	for _, other := range []string{"testdata", "othermod"} {
+		noError(t, os.MkdirAll(filepath.Join(dir, other), os.FileMode(0700)))
+		noError(t, ioutil.WriteFile(filepath.Join(dir, other, "goverify.json"), []byte(foreign), os.FileMode(0600)))
+	}
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "othermod", "go.mod"), []byte("module example.com/other\n"), os.FileMode(0600)))
+	var ran []string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if strings.HasSuffix(cmd.Path, "git") {
+				panicIfNotNil2(cmd.Stdout.Write([]byte("a.go\nlegacy/b.go\nlegacy/old/c.go\n")))
+				return nil
+			}
+			if len(cmd.Args) == 2 && cmd.Args[1] == "tool" {
+				panicIfNotNil2(cmd.Stdout.Write([]byte("cover\n")))
+				return nil
+			}
+			if cmd.Args[1] == "install" {
+				return nil
+			}
+			if cmd.Args[1] == "list" {
+				panicIfNotNil2(cmd.Stdout.Write([]byte(strings.Join([]string{dir, filepath.Join(dir, "legacy"), filepath.Join(dir, "legacy", "old")}, "\n") + "\n")))
+				return nil
+			}
+			if cmd.Args[1] == "test" {
+				// Coverage of legacy packages is only judged by the legacy config
+				profile := "mode: atomic\nexample.com/m/a.go:1.1,3.2 9 1\nexample.com/m/a.go:4.1,5.2 1 0\nexample.com/m/legacy/b.go:1.1,3.2 1 1\nexample.com/m/legacy/b.go:4.1,5.2 3 0\n"
+				noError(t, ioutil.WriteFile(cmd.Args[5], []byte(profile), os.FileMode(0600)))
+				cmd.Args[5] = "${coverprofile}"
+			}
+			rel, _ := filepath.Rel(dir, cmd.Dir)
+			if cmd.Dir == "" {
+				rel = "."
+			}
+			ran = append(ran, rel+": "+strings.Join(cmd.Args, " "))
+			return nil
+		},
+		configFile: configFile,
+	}
+	noError(t, m.main())
+	expected := []string{
+		".: gocyclo -over 10 a.go",
+		".: gocyclo -over 30 legacy/b.go",
+		".: go test -covermode atomic -coverprofile ${coverprofile} -race -parallel=8 -timeout 3s -cpu 4 .",
+		"legacy: go test -covermode atomic -coverprofile ${coverprofile} -race -parallel=8 -timeout 3s -cpu 4 ./...",
+		"legacy/old: oldlint ./...",
+	}
+	if strings.Join(ran, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected commands:\n%s", strings.Join(ran, "\n"))
+	}
+}
+
+func TestNestedArgOps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestNestedArgOps")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, os.MkdirAll(filepath.Join(dir, "sub"), os.FileMode(0700)))
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{"simultaneousRuns": 1, "checks": [{"macro": "go-vet", "check": {"argsPrepend": ["-C", "."], "argsAppend": ["-x"]}}]}`), os.FileMode(0600)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "goverify.json"), []byte(`{"checks": [{"macro": "go-vet", "check": {"argsRemove": ["-x", "-C"], "argsAppend": ["-w"]}}]}`), os.FileMode(0600)))
+	var ran []string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if cmd.Args[1] == "list" {
+				panicIfNotNil2(cmd.Stdout.Write([]byte(dir + "\n" + filepath.Join(dir, "sub") + "\n")))
+				return nil
+			}
+			ran = append(ran, strings.Join(cmd.Args, " "))
+			return nil
+		},
+		configFile: configFile,
+	}
+	noError(t, m.main())
+	expected := "go -C . vet . -x|go . vet ./... -w"
+	if strings.Join(ran, "|") != expected {
+		t.Errorf("Unexpected commands %s", ran)
+	}
+
+	// Packages of a nested config that disables the check are not checked by the root run either
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "goverify.json"), []byte(`{"checks": [{"macro": "go-vet", "disabled": true}]}`), os.FileMode(0600)))
+	ran = nil
+	noError(t, m.main())
+	if strings.Join(ran, "|") != "go -C . vet . -x" {
+		t.Errorf("Unexpected commands %s", ran)
+	}
+}
+
+func TestVarExpander(t *testing.T) {
+	v := &varExpander{
+		builtins: map[string]string{"root": "/src/repo"},
+		lookupEnv: func(name string) (string, bool) {
+			if name == "COVER_MIN" {
+				return "40", true
+			}
+			return "", false
+		},
+	}
+	for in, expected := range map[string]string{
+		"${root}/bin":             "/src/repo/bin",
+		"-over=${OVER:-15}":       "-over=15",
+		"${COVER_MIN}":            "40",
+		"$${root} ${file}":        "${root} ${file}",
+		"nothing to expand $1 $x": "nothing to expand $1 $x",
+	} {
+		out, err := v.expand(in)
+		noError(t, err)
+		if out != expected {
+			t.Errorf("Expand %s: got %s, expected %s", in, out, expected)
+		}
+	}
+	_, err := v.expand("${UNSET}")
+	errorSeen(t, err)
+	if !strings.Contains(err.Error(), "variable UNSET is not set") {
+		t.Errorf("Unexpected error %s", err)
+	}
+	_, err = v.expand("${root")
+	errorSeen(t, err)
+
+	raw, err := v.expandJSON(json.RawMessage(`{"coverage": "${COVER_MIN}", "ignoreDir": ["${root}/vendor"]}`))
+	noError(t, err)
+	var cover coverageValidator
+	noError(t, json.Unmarshal(raw, &cover))
+	if cover.RequiredCoverage != 40 || cover.IgnoreDir[0] != "/src/repo/vendor" {
+		t.Errorf("Unexpected validator %s", raw)
+	}
+}
+
+func TestVarsInChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestVarsInChecks")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.24\n"), os.FileMode(0600)))
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"name": "custom", "cmd": "lint", "check": {"args": ["-module=${module}", "-root=${root}", "--file=${file}"]}, "each": {"cmd": "git", "args": ["ls-files"]}}
+  ]
+}`), os.FileMode(0600)))
+	var ran string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if strings.HasSuffix(cmd.Path, "git") {
+				panicIfNotNil2(cmd.Stdout.Write([]byte("a.go\n")))
+				return nil
+			}
+			ran = strings.Join(cmd.Args, " ")
+			return nil
+		},
+		configFile: configFile,
+	}
+	noError(t, m.main())
+	if ran != "lint -module=example.com/m -root="+dir+" --file=a.go" {
+		t.Errorf("Unexpected command %s", ran)
+	}
+}
+
+func TestCheckEnvAndDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCheckEnvAndDir")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"macro": "go-test", "env": {"CGO_ENABLED": "0", "GOFLAGS": "-mod=vendor"}},
+    {"name": "sub", "cmd": "make", "dir": "sub", "check": {"args": ["lint"]}}
+  ]
+}`), os.FileMode(0600)))
+	var dirs []string
+	var env []string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			dirs = append(dirs, cmd.Dir)
+			if cmd.Args[0] == "go" {
+				env = cmd.Env
+			}
+			return nil
+		},
+		configFile: configFile,
+	}
+	noError(t, m.main())
+	if strings.Join(dirs, ",") != dir+","+filepath.Join(dir, "sub") {
+		t.Errorf("Unexpected dirs %s", dirs)
+	}
+	if len(env) < 2 || env[len(env)-2] != "CGO_ENABLED=0" || env[len(env)-1] != "GOFLAGS=-mod=vendor" {
+		t.Errorf("Unexpected env %s", env)
+	}
+}
+
+func TestGoVersionAtLeast(t *testing.T) {
+	for _, tc := range []struct {
+		have, want string
+		expected   bool
+	}{
+		{"go1.22.3", "1.22", true},
+		{"go1.22", "1.22.1", false},
+		{"go1.21.9", "1.22", false},
+		{"go1.23rc1", "1.22", true},
+		{"go2", "1.30", true},
+	} {
+		if goVersionAtLeast(tc.have, tc.want) != tc.expected {
+			t.Errorf("goVersionAtLeast(%s, %s) should be %v", tc.have, tc.want, tc.expected)
+		}
+	}
+}
+
+func TestWhenConditions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWhenConditions")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"name": "native", "cmd": "true", "check": {"args": ["native"]}, "when": {"goos": ["${goos}"]}},
+    {"name": "elsewhere", "cmd": "true", "check": {"args": ["elsewhere"]}, "when": {"goos": ["!${goos}"]}},
+    {"name": "new", "cmd": "true", "check": {"args": ["new"]}, "when": {"goVersion": "1.30"}},
+    {"name": "race", "cmd": "true", "check": {"args": ["race"]}, "when": {"cgo": true}},
+    {"name": "ci", "cmd": "true", "check": {"args": ["ci"]}, "when": {"env": ["GOVERIFY_TEST_UNSET"]}}
+  ]
+}`), os.FileMode(0600)))
+	var ran []string
+	var output bytes.Buffer
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if cmd.Args[0] == "go" {
+				_, err := cmd.Stdout.Write([]byte(map[string]string{"GOVERSION": "go1.30.1", "CGO_ENABLED": "0"}[cmd.Args[2]] + "\n"))
+				return err
+			}
+			ran = append(ran, cmd.Args[1])
+			return nil
+		},
+		configFile: configFile,
+		output:     &output,
+	}
+	noError(t, m.main())
+	if strings.Join(ran, ",") != "native,new" {
+		t.Errorf("Unexpected checks run %s", ran)
+	}
+	for _, expected := range []string{"Skipped elsewhere: goos", "Skipped race: cgo is disabled", "Skipped ci: GOVERIFY_TEST_UNSET is not set"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in output %s", expected, output.String())
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestProfiles")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "defaultProfile": "precommit",
+  "profiles": {
+    "precommit": {"checks": ["fast"]},
+    "ci": {"extends": "precommit", "checks": ["slow"], "simultaneousRuns": 3},
+    "nightly": {"extends": "ci", "overrides": {"slow": {"check": {"argsAppend": ["-race"]}}}},
+    "vet": {"checks": ["go-vet"], "overrides": {"go-vet": {"check": {"argsAppend": ["-y"]}}}},
+    "vetall": {"extends": "vet", "overrides": {"go-vet": {"check": {"argsPrepend": ["-C", "."], "argsRemove": ["-x"]}}}}
+  },
+  "checks": [
+    {"name": "fast", "cmd": "true", "check": {"args": ["fast"]}},
+    {"name": "slow", "cmd": "true", "check": {"args": ["slow"]}},
+    {"macro": "go-vet", "check": {"argsAppend": ["-x"]}}
+  ]
+}`), os.FileMode(0600)))
+	for _, tc := range []struct {
+		profile  string
+		expected string
+	}{
+		{"", "fast"},
+		{"ci", "fast,slow"},
+		{"nightly", "fast,slow -race"},
+		// Arg operators of profiles and the checks they override all apply to the macro's args
+		{"vet", "vet ./... -x -y"},
+		{"vetall", "-C . vet ./... -y"},
+	} {
+		var ran []string
+		m := &goverify{
+			run: func(cmd *exec.Cmd) error {
+				ran = append(ran, strings.Join(cmd.Args[1:], " "))
+				return nil
+			},
+			configFile: configFile,
+			profile:    tc.profile,
+		}
+		noError(t, m.main())
+		if strings.Join(ran, ",") != tc.expected {
+			t.Errorf("Profile %q ran %s, expected %s", tc.profile, ran, tc.expected)
+		}
+	}
+	m := &goverify{configFile: configFile, profile: "ci"}
+	conf, err := m.loadConfig()
+	noError(t, err)
+	if conf.SimultaneousRuns != 3 {
+		t.Errorf("Expected ci to set simultaneousRuns, got %d", conf.SimultaneousRuns)
+	}
+	m = &goverify{configFile: configFile, profile: "weekly"}
+	if _, err = m.loadConfig(); err == nil || err.Error() != "unknown profile weekly: expected one of ci, nightly, precommit, vet, vetall" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRegexValidator(t *testing.T) {
+	v := &regexValidator{
+		Stdout: &regexPatterns{FailOn: []string{"^E"}, WarnOn: []string{"."}, Ignore: []string{"generated"}},
+		Stderr: &regexPatterns{FailOn: []string{"panic"}},
+	}
+	findings, err := v.Findings(bytes.NewBufferString("E1 bad\nW1 style\nE2 generated\n\n"), bytes.NewBufferString("downloading\n"))
+	noError(t, err)
+	if formatFindings(findings) != "error: E1 bad\nwarning: W1 style" {
+		t.Errorf("Unexpected findings %s", formatFindings(findings))
+	}
+	if err = v.Check(bytes.NewBufferString("E1 bad\n"), &bytes.Buffer{}); err == nil || err.Error() != "1 error in output" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	noError(t, v.Check(bytes.NewBufferString("W1 style\n"), &bytes.Buffer{}))
+	if err = v.Check(&bytes.Buffer{}, bytes.NewBufferString("panic: oops\n")); err == nil {
+		t.Error("Expected stderr failOn to fail")
+	}
+	v = &regexValidator{
+		Stdout: &regexPatterns{WarnOn: []string{"("}},
+	}
+	if _, err = v.Findings(bytes.NewBufferString("W1\n"), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "invalid stdout warnOn pattern") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	// Bad patterns are found before anything runs
+	badConfig, cleanupBad := tempConfig(t, `{
+  "checks": [{"cmd": "lint", "check": {"args": ["x"]}, "validate": {"type": "regex", "stdout": {"failOn": ["ok", "("]}}}]
+}`)
+	defer cleanupBad()
+	_, err = (&goverify{configFile: badConfig}).loadConfig()
+	if err == nil || !strings.Contains(err.Error(), ":2:114: checks[0].validate.stdout.failOn[1]: invalid pattern \"(\"") {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// A check can change the patterns of its macro, and findings are classified even when the tool exits with an
+	// error
+	filename, cleanup := tempConfig(t, `{
+  "macros": {"lint": {"name": "lint", "cmd": "lint", "check": {"args": ["./..."]}, "validate": {"type": "regex", "stdout": {"failOn": ["."]}}}},
+  "checks": [{"macro": "lint", "validate": {"stdout": {"warnOn": ["."], "ignore": ["generated"]}}}]
+}`)
+	defer cleanup()
+	output := "a.go:1: style\n"
+	var printed bytes.Buffer
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			panicIfNotNil2(cmd.Stdout.Write([]byte(output)))
+			return errors.New("exit status 1")
+		},
+		configFile:  filename,
+		output:      &printed,
+		maxWarnings: -1,
+	}
+	noError(t, m.main())
+	if !strings.Contains(printed.String(), "lint: warning: a.go:1: style") {
+		t.Errorf("Unexpected output %s", printed.String())
+	}
+	output = "gen.go:1: generated\n"
+	if err = m.main(); err == nil || err.Error() != "exit status 1" {
+		t.Errorf("Expected the exit code to fail without findings, got %v", err)
+	}
+	if !strings.Contains(printed.String(), "gen.go:1: generated\n") {
+		t.Errorf("Expected the output of the failed check in %s", printed.String())
+	}
+
+	// A macro's validator can be replaced by one of another type
+	coverConfig, cleanupCover := tempConfig(t, `{"checks": [{"macro": "go-cover", "validate": {"type": "regex", "stdout": {"failOn": ["FAIL"]}}}]}`)
+	defer cleanupCover()
+	m.configFile = coverConfig
+	m.run = func(cmd *exec.Cmd) error {
+		if len(cmd.Args) == 2 && cmd.Args[1] == "tool" {
+			panicIfNotNil2(cmd.Stdout.Write([]byte("cover\n")))
+			return nil
+		}
+		panicIfNotNil2(cmd.Stdout.Write([]byte("FAIL a\n")))
+		return nil
+	}
+	if err = m.main(); err == nil || err.Error() != "1 error in output" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSeverity")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"name": "cyclo", "cmd": "gocyclo", "check": {"args": ["."]}, "severity": "warning"},
+    {"name": "notes", "cmd": "notes", "check": {"args": ["."]}, "severity": "info"},
+    {"name": "lint", "cmd": "lint", "check": {"args": ["."]}, "validate": {"type": "regex", "stdout": {"warnOn": ["."]}}}
+  ]
+}`), os.FileMode(0600)))
+	for _, tc := range []struct {
+		maxWarnings int
+		expectErr   bool
+	}{
+		{-1, false},
+		{4, false},
+		{3, true},
+	} {
+		var output bytes.Buffer
+		m := &goverify{
+			run: func(cmd *exec.Cmd) error {
+				_, err := cmd.Stdout.Write([]byte(cmd.Args[0] + ":1: first\n" + cmd.Args[0] + ":2: second\n"))
+				noError(t, err)
+				if cmd.Args[0] == "lint" {
+					return nil
+				}
+				return errors.New("exit status 1")
+			},
+			configFile:  configFile,
+			output:      &output,
+			maxWarnings: tc.maxWarnings,
+		}
+		err = m.main()
+		if (err != nil) != tc.expectErr {
+			t.Errorf("max warnings %d: unexpected error %v", tc.maxWarnings, err)
+		}
+		for _, expected := range []string{"cyclo: warning: gocyclo:1: first", "notes: info: notes:2: second", "Warnings: 4\n  cyclo: 2\n  lint: 2\n"} {
+			if !strings.Contains(output.String(), expected) {
+				t.Errorf("Expected %q in output %s", expected, output.String())
+			}
+		}
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestBaseline")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"name": "errcheck", "cmd": "errcheck", "check": {"args": ["./..."]}}
+  ]
+}`), os.FileMode(0600)))
+	source := "package a\n\nfunc f() {\n\tg()\n}\n\nfunc h() {\n\tg()\n}\n"
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(source), os.FileMode(0600)))
+	findings := "a.go:4:3: g()\na.go:8:3: g()\n"
+	var output bytes.Buffer
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			_, err := cmd.Stdout.Write([]byte(findings))
+			noError(t, err)
+			if findings == "" {
+				return nil
+			}
+			return errors.New("exit status 1")
+		},
+		configFile: configFile,
+		output:     &output,
+		args:       []string{"baseline", "write"},
+	}
+	noError(t, m.main())
+	if !strings.Contains(output.String(), "Wrote 2 findings") {
+		t.Errorf("Unexpected output %s", output.String())
+	}
+	m.args = nil
+	noError(t, m.main())
+
+	// Moving the code keeps the findings in the baseline, but a new finding fails
+	source = "package a\n\nfunc f() {\n\tg()\n}\n\nfunc h() {\n\tg()\n}\n\nfunc i() {\n\tk()\n}\n"
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("// moved\n"+source), os.FileMode(0600)))
+	findings = "a.go:5:3: g()\na.go:9:3: g()\na.go:13:3: k()\n"
+	if err = m.main(); err == nil {
+		t.Error("Expected the new finding to fail")
+	}
+
+	// Fixing a finding leaves its baseline entry stale
+	output.Reset()
+	findings = ""
+	noError(t, m.main())
+	if !strings.Contains(output.String(), "2 baseline entries no longer match a finding") || !strings.Contains(output.String(), "errcheck: a.go: g()") {
+		t.Errorf("Unexpected output %s", output.String())
+	}
+}
+
+func TestSuppressionComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSuppressionComments")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"macro": "errcheck"}
+  ]
+}`), os.FileMode(0600)))
+	source := `package a
+
+func f() {
+	//goverify:ignore errcheck nothing to do if it fails
+	g()
+	g() //goverify:ignore errcheck same line
+	g()
+	//goverify:ignore errcheck not needed
+}
+
+var s = "//goverify:ignore errcheck"
+`
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(source), os.FileMode(0600)))
+	findings := "a.go:5:2: g()\na.go:6:2: g()\n"
+	var output bytes.Buffer
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			if cmd.Args[0] == "go" {
+				return nil
+			}
+			_, err := cmd.Stdout.Write([]byte(findings))
+			noError(t, err)
+			return errors.New("exit status 1")
+		},
+		configFile: configFile,
+		output:     &output,
+	}
+	// The check is named by its macro, and files without findings are still checked for unused suppressions
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "c.go"), []byte("package a\n\nfunc h() {\n\t//goverify:ignore errcheck fixed since\n\tg()\n}\n"), os.FileMode(0600)))
+	noError(t, m.main())
+	if output.String() != "a.go:8: //goverify:ignore errcheck is unused\nc.go:4: //goverify:ignore errcheck is unused\n" {
+		t.Errorf("Unexpected output %q", output.String())
+	}
+	findings = "a.go:7:2: g()\n"
+	if err = m.main(); err == nil {
+		t.Error("Expected the unsuppressed finding to fail")
+	}
+
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "b.go"), []byte("package a\n\n//goverify:ignore errcheck\n"), os.FileMode(0600)))
+	findings = "a.go:5:2: g()\na.go:6:2: g()\n"
+	if err = m.main(); err == nil || err.Error() != "b.go:3: //goverify:ignore needs a check and a reason" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestCoverageBaseline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCoverageBaseline")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	profile := "mode: set\nexample.com/m/a.go:1.1,3.2 8 1\nexample.com/m/a.go:4.1,5.2 2 0\nexample.com/m/b/b.go:1.1,3.2 4 1\n"
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "coverage.json"), []byte(`{"total": 85, "packages": {"example.com/m": 81, "example.com/m/b": 100}}`), os.FileMode(0600)))
+	c := coverageValidator{
+		Baseline: &coverageBaseline{File: "coverage.json", MaxDrop: 1},
+		dir:      dir,
+	}
+	noError(t, c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{}))
+	c.Baseline.MaxDrop = 0.5
+	err = c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Coverage of example.com/m dropped from 81.000000 to 80.000000, more than 0.500000\n") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, row := range []string{"example.com/m  81.0%  80.0%  -1.0%", "example.com/m/b  100.0%  100.0%  +0.0%", "total   85.0%   85.7%  +0.7%"} {
+		if !strings.Contains(strings.Join(strings.Fields(err.Error()), " "), strings.Join(strings.Fields(row), " ")) {
+			t.Errorf("Expected row %q in %s", row, err)
+		}
+	}
+
+	// A git ref is checked out into a worktree, and the check run there
+	configFile := filepath.Join(dir, "goverify.json")
+	noError(t, ioutil.WriteFile(configFile, []byte(`{
+  "checks": [
+    {"macro": "go-cover", "validate": {"coverage": 50, "baseline": {"ref": "origin/main"}}}
+  ]
+}`), os.FileMode(0600)))
+	var worktree string
+	m := &goverify{
+		run: func(cmd *exec.Cmd) error {
+			switch {
+			case cmd.Args[0] == "git" && cmd.Args[1] == "rev-parse":
+				panicIfNotNil2(cmd.Stdout.Write([]byte(dir + "\n")))
+			case cmd.Args[0] == "git" && cmd.Args[2] == "add":
+				worktree = cmd.Args[4]
+			case cmd.Args[0] == "go" && len(cmd.Args) > 5:
+				written := profile
+				if cmd.Dir == worktree {
+					written = "mode: set\nexample.com/m/a.go:1.1,3.2 8 1\nexample.com/m/a.go:4.1,5.2 2 1\n"
+				}
+				noError(t, ioutil.WriteFile(cmd.Args[5], []byte(written), os.FileMode(0600)))
+			}
+			return nil
+		},
+		configFile: configFile,
+	}
+	err = m.main()
+	if err == nil || !strings.Contains(err.Error(), "Coverage of example.com/m dropped from 100.000000 to 80.000000") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if worktree == "" {
+		t.Error("Expected a worktree of the ref")
+	}
+}
+
+func TestPatchCoverage(t *testing.T) {
+	diff := `diff --git a/pkg/a.go b/pkg/a.go
+index 1..2 100644
+--- a/pkg/a.go
++++ b/pkg/a.go
+@@ -3,0 +4,4 @@ func f() {
++	x()
++	if y {
++		z()
++	}
+@@ -20 +24 @@ func g() {
+-	old()
++	comment()
+--- a/gone.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package a
+`
+	changed, err := parseDiffLines(diff)
+	noError(t, err)
+	if len(changed) != 1 || strings.Join(strings.Fields(fmt.Sprint(changed["pkg/a.go"])), " ") != "[4 5 6 7 24]" {
+		t.Fatalf("Unexpected changed lines %v", changed)
+	}
+	dir, err := ioutil.TempDir("", "TestPatchCoverage")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n"), os.FileMode(0600)))
+	// main.go is the one at the root, not the one in cmd/x
+	changed["main.go"] = []int{1}
+	profile := `mode: set
+example.com/m/pkg/a.go:4.2,5.8 2 1
+example.com/m/pkg/a.go:5.8,7.3 1 0
+example.com/m/pkg/b.go:1.1,2.2 1 0
+example.com/m/cmd/x/main.go:1.1,2.2 1 0
+example.com/m/main.go:1.1,2.2 1 1
+`
+	c := coverageValidator{
+		Patch:        &patchCoverage{Base: "origin/main", Coverage: 40},
+		changedLines: changed,
+		dir:          dir,
+	}
+	noError(t, c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{}))
+	c.Patch.Coverage = 50
+	err = c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil || err.Error() != "Patch coverage 40.000000 (2 of 5 changed lines) less than required 50.000000\n  pkg/a.go:5-7" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestCoverageReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCoverageReports")
+	noError(t, err)
+	defer func() { panicIfNotNil(os.RemoveAll(dir)) }()
+	noError(t, os.MkdirAll(filepath.Join(dir, "pkg"), os.FileMode(0700)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n"), os.FileMode(0600)))
+	noError(t, ioutil.WriteFile(filepath.Join(dir, "pkg", "a.go"), []byte("package pkg\n\nfunc f(a int) bool {\n\tif a < 2 {\n\t\treturn true\n\t}\n\treturn false\n}\n"), os.FileMode(0600)))
+	profile := "mode: set\nexample.com/m/pkg/a.go:3.21,4.11 1 1\nexample.com/m/pkg/a.go:4.11,6.3 1 1\nexample.com/m/pkg/a.go:7.2,7.14 1 0\n"
+	c := coverageValidator{
+		HTML:      "coverage.html",
+		Cobertura: "coverage.xml",
+		dir:       dir,
+	}
+	noError(t, c.CheckProfile([]byte(profile), &bytes.Buffer{}, &bytes.Buffer{}))
+	html, err := ioutil.ReadFile(filepath.Join(dir, "coverage.html"))
+	noError(t, err)
+	for _, expected := range []string{`example.com/m/pkg/a.go (66.7%)`, `if a &lt; 2 </span><span class="cov1">{`, `<span class="cov0">return false</span>`} {
+		if !strings.Contains(string(html), expected) {
+			t.Errorf("Expected %q in html report %s", expected, html)
+		}
+	}
+	cobertura, err := ioutil.ReadFile(filepath.Join(dir, "coverage.xml"))
+	noError(t, err)
+	for _, expected := range []string{`lines-covered="4" lines-valid="5"`, `<package name="example.com/m/pkg" line-rate="0.8"`, `<class name="a.go" filename="pkg/a.go"`, `<line number="7" hits="0"></line>`} {
+		if !strings.Contains(string(cobertura), expected) {
+			t.Errorf("Expected %q in cobertura report %s", expected, cobertura)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// nestedConfig is a config file below the root config.  Its checks apply to the files and packages of its
+// directory: a check with the same checkID as a parent check overrides it, and any other check is added.
+type nestedConfig struct {
+	// dir is slash separated and relative to the root config
+	dir      string
+	filename string
+	checks   []check
+}
+
+// checkID identifies a check across nested configs: its name, or the macro it uses if it has no name
+func checkID(c check) string {
+	return nonEmptyStr(c.Name, c.Macro)
+}
+
+// overrideCheck returns parent with the properties set by override replacing its own
+func overrideCheck(parent, override check) check {
+	merged := override
+	merged.mergePropertiesFrom(parent)
+	merged.Macro = nonEmptyStr(override.Macro, parent.Macro)
+	merged.With = mergeParams(override.With, parent.With)
+	merged.Validator, _ = mergeValidatorJSON(override.Validator, parent.Validator)
+	merged.Disabled = override.Disabled || parent.Disabled
+	return merged
+}
+
+func underDir(file string, dir string) bool {
+	return dir == "" || dir == "." || file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// inScope returns true if file, relative to the root config, is checked by this definition of the check rather
+// than by one in a nested config
+func (c *check) inScope(file string) bool {
+	if !underDir(file, c.scopeDir) {
+		return false
+	}
+	for _, dir := range c.excludeScopes {
+		if underDir(file, dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// rootRelative returns file, listed from the check's directory, relative to the root config
+func (c *check) rootRelative(file string) string {
+	if c.workDir == "" || c.workDir == c.rootPath {
+		return file
+	}
+	rel, err := filepath.Rel(c.rootPath, filepath.Join(c.workDir, filepath.FromSlash(file)))
+	if err != nil {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+// packageInDirs returns true if the import path pkg is for a package in one of dirs
+func packageInDirs(pkg string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasSuffix(pkg, "/"+dir) || strings.Contains(pkg, "/"+dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// projectRootFiles mark a directory as the root of another module or repository, whose configs are its own
+var projectRootFiles = []string{"go.mod", ".git", ".hg", ".svn"}
+
+// isProjectRoot returns true if dir is the root of a module or repository
+func isProjectRoot(dir string) bool {
+	for _, name := range projectRootFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNestedConfigs finds the config files in directories below the root config.  Hidden directories, testdata,
+// those in ignoreDir, those that can not be read and other modules or repositories are not searched.
+func (p *goverify) loadNestedConfigs(conf *config) error {
+	err := filepath.Walk(conf.rootPath, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			if dir == conf.rootPath {
+				return err
+			}
+			if p.logger != nil {
+				p.logger.Printf("Not searching %s for configs: %s", dir, err)
+			}
+			return filepath.SkipDir
+		}
+		if !info.IsDir() || dir == conf.rootPath {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || info.Name() == "testdata" || containsStr(conf.IgnoreDir, info.Name()) {
+			return filepath.SkipDir
+		}
+		if isProjectRoot(dir) {
+			return filepath.SkipDir
+		}
+		filename, err := findConfigFile(dir, false)
+		if err != nil || filename == "" {
+			return err
+		}
+		rel, err := filepath.Rel(conf.rootPath, dir)
+		if err != nil {
+			return err
+		}
+		nested, err := p.loadNestedConfig(conf, filepath.ToSlash(rel), filename)
+		if err != nil {
+			return err
+		}
+		conf.nested = append(conf.nested, nested)
+		return nil
+	})
+	sort.SliceStable(conf.nested, func(i, j int) bool {
+		return strings.Count(conf.nested[i].dir, "/") < strings.Count(conf.nested[j].dir, "/")
+	})
+	return err
+}
+
+func (p *goverify) loadNestedConfig(conf *config, dir string, filename string) (nestedConfig, error) {
+	fileContent, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nestedConfig{}, err
+	}
+	var nestedConf config
+	if err = decodeConfigFile(filename, fileContent, &nestedConf); err != nil {
+		return nestedConfig{}, fmt.Errorf("unable to load config %s: %s", filename, err)
+	}
+	inherited := make(map[string]check)
+	for _, c := range effectiveChecks(conf, dir) {
+		inherited[checkID(c)] = c
+	}
+	validationConf := *conf
+	validationConf.Checks = nestedConf.Checks
+	if err = validateConfig(filename, fileContent, &validationConf, inherited); err != nil {
+		return nestedConfig{}, err
+	}
+	return nestedConfig{
+		dir:      dir,
+		filename: filename,
+		checks:   nestedConf.Checks,
+	}, nil
+}
+
+// effectiveChecks returns the checks, as defined by the root and nested configs already loaded, that apply to
+// dir
+func effectiveChecks(conf *config, dir string) []check {
+	var ret []check
+	index := make(map[string]int)
+	apply := func(checks []check) {
+		for _, c := range checks {
+			id := checkID(c)
+			if i, exists := index[id]; exists {
+				ret[i] = overrideCheck(ret[i], c)
+				continue
+			}
+			index[id] = len(ret)
+			ret = append(ret, c)
+		}
+	}
+	apply(conf.Checks)
+	for _, nested := range conf.nested {
+		if underDir(dir, nested.dir) {
+			apply(nested.checks)
+		}
+	}
+	return ret
+}
+
+// scopedChecks returns every check to run.  A check is run once for the root config, and again for each nested
+// config that changes it, with each run limited to the files and packages closest to its config.
+func scopedChecks(conf *config) []check {
+	type scopedDef struct {
+		dir    string
+		source string
+		def    check
+	}
+	var ids []string
+	defs := make(map[string][]scopedDef)
+	add := func(dir string, filename string, checks []check) {
+		for i, c := range checks {
+			id := checkID(c)
+			if _, exists := defs[id]; !exists {
+				ids = append(ids, id)
+			}
+			defs[id] = append(defs[id], scopedDef{
+				dir:    dir,
+				source: fmt.Sprintf("%s checks[%d]", filename, i),
+				def:    c,
+			})
+		}
+	}
+	add("", conf.configFile, conf.Checks)
+	for _, nested := range conf.nested {
+		add(nested.dir, nested.filename, nested.checks)
+	}
+	var ret []check
+	for _, id := range ids {
+		if !conf.profile.includes(id) {
+			continue
+		}
+		for i, scoped := range defs[id] {
+			c := scoped.def
+			c.definedBy = []definitionLevel{{source: scoped.source, def: scoped.def}}
+			inherits := false
+			// Inherit from the closest definition in a parent directory
+			for j := i - 1; j >= 0; j-- {
+				parent := defs[id][j]
+				if parent.dir != scoped.dir && underDir(scoped.dir, parent.dir) {
+					c = overrideCheck(ret[len(ret)-(i-j)], scoped.def)
+					c.definedBy = append([]definitionLevel{{source: scoped.source, def: scoped.def}}, ret[len(ret)-(i-j)].definedBy...)
+					inherits = true
+					break
+				}
+			}
+			if override, exists := conf.profile.override(id); exists && !inherits {
+				// Profiles change the outermost definitions, so nested configs still refine them
+				c = overrideCheck(c, override)
+				c.definedBy = append([]definitionLevel{{source: conf.profile.sources[id], def: override}}, c.definedBy...)
+			}
+			c.scopeDir = scoped.dir
+			c.scopeWorkDir = ""
+			if scoped.dir != "" {
+				c.scopeWorkDir = filepath.Join(conf.rootPath, filepath.FromSlash(scoped.dir))
+			}
+			c.excludeScopes = nil
+			for _, other := range defs[id][i+1:] {
+				if other.dir != scoped.dir && underDir(other.dir, scoped.dir) {
+					c.excludeScopes = append(c.excludeScopes, path.Clean(other.dir))
+				}
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// scopePackages replaces ./... in the args of a check that runs once with the packages it checks itself, leaving
+// out those of nested configs that define the check.  It returns nil if there are no packages left to check.
+func (p *goverify) scopePackages(c check, args []string) ([]string, error) {
+	if c.Each != nil || len(c.excludeScopes) == 0 || !containsStr(args, "./...") {
+		return args, nil
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := c.command("go", "list", "-e", "-f", "{{.Dir}}", "./...")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		return nil, fmt.Errorf("unable to list packages of %s: %s: %s", c.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	root := evalSymlinks(c.rootPath)
+	workDir := evalSymlinks(c.workDir)
+	var packages []string
+	for _, dir := range strings.Split(stdout.String(), "\n") {
+		if dir == "" {
+			continue
+		}
+		dir = evalSymlinks(dir)
+		fromRoot, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !c.inScope(filepath.ToSlash(fromRoot)) {
+			continue
+		}
+		pkg, err := filepath.Rel(workDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		if pkg != "." {
+			pkg = "./" + filepath.ToSlash(pkg)
+		}
+		packages = append(packages, pkg)
+	}
+	if len(packages) == 0 {
+		return nil, nil
+	}
+	ret := make([]string, 0, len(args)+len(packages))
+	for _, arg := range args {
+		if arg == "./..." {
+			ret = append(ret, packages...)
+			continue
+		}
+		ret = append(ret, arg)
+	}
+	return ret, nil
+}
+
+// evalSymlinks returns dir with symlinks resolved, so it can be compared with the directories go lists
+func evalSymlinks(dir string) string {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		return resolved
+	}
+	return dir
+}
@@ -1,5 +1,9 @@
 package main
 
+// deprecatedNamespace prefixes macros that are kept for older configs but should no longer be used.  A config can
+// still refer to them by their bare name, with a warning.
+const deprecatedNamespace = "deprecated/"
+
 // List of predefined macros that users can use in their goverify.json file
 var macros = `{
   "macros": {
@@ -14,7 +18,7 @@ var macros = `{
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "golang.org/x/tools/cmd/goimports"]
+        "args": ["install", "golang.org/x/tools/cmd/goimports@latest"]
       },
       "each": {
         "cmd": "git",
@@ -35,83 +39,106 @@ var macros = `{
         "args": ["ls-files", "--", "*.go"]
       }
     },
-    "vet": {
-      "name": "vet",
-      "cmd": "go",
+    "gofumpt": {
+      "name": "gofumpt fix",
+      "cmd": "gofumpt",
+      "fix": {
+        "args": ["-w", "-l", "$1"]
+      },
       "check": {
-        "args": ["tool", "vet", "$1"]
+        "args": ["-l", "$1"]
       },
-      "gotool": "vet",
       "install": {
         "cmd": "go",
-        "args": ["get", "golang.org/x/tools/cmd/vet"]
+        "args": ["install", "mvdan.cc/gofumpt@latest"]
       },
       "each": {
         "cmd": "git",
         "args": ["ls-files", "--", "*.go"]
       }
     },
-    "golint": {
-      "name": "code lint",
-      "cmd": "golint",
+    "go-vet": {
+      "name": "vet",
+      "cmd": "go",
       "check": {
-        "args": ["-min_confidence=.3", "$1"]
-      },
-      "install": {
-        "cmd": "go",
-        "args": ["get", "golang.org/x/lint/golint"]
+        "args": ["vet", "./..."]
       },
-      "each": {
-        "cmd": "git",
-        "args": ["ls-files", "--", "*.go"]
+      "validate": {
+        "type": "returncode"
       }
     },
-    "gocyclo": {
-      "name": "cyclomatic check",
-      "cmd": "gocyclo",
+    "staticcheck": {
+      "name": "staticcheck",
+      "cmd": "staticcheck",
       "check": {
-        "args": ["-over", "10", "$1"]
+        "args": ["./..."]
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/fzipp/gocyclo"]
-      },
-      "each": {
-        "cmd": "git",
-        "args": ["ls-files", "--", "*.go"]
+        "args": ["install", "honnef.co/go/tools/cmd/staticcheck@latest"]
       }
     },
-    "varcheck": {
-      "name": "varcheck check",
-      "cmd": "varcheck",
+    "govulncheck": {
+      "name": "vulnerability check",
+      "cmd": "govulncheck",
       "check": {
         "args": ["./..."]
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/opennota/check/cmd/varcheck"]
+        "args": ["install", "golang.org/x/vuln/cmd/govulncheck@latest"]
+      },
+      "validate": {
+        "type": "returncode"
       }
     },
-    "aligncheck": {
-      "name": "alignment check",
-      "cmd": "aligncheck",
+    "mod-tidy": {
+      "name": "go mod tidy",
+      "cmd": "go",
+      "fix": {
+        "args": ["-C", "$1", "mod", "tidy"]
+      },
       "check": {
-        "args": ["./..."]
+        "args": ["-C", "$1", "mod", "tidy", "-diff"]
       },
-      "install": {
-        "cmd": "go",
-        "args": ["get", "github.com/opennota/check/cmd/aligncheck"]
+      "then": [{
+        "args": ["-C", "$1", "mod", "verify"]
+      }],
+      "each": {
+        "cmd": "git",
+        "args": ["ls-files", "--", "go.mod", "*/go.mod"],
+        "dirs": true
+      },
+      "validate": {
+        "type": "returncode"
       }
     },
-    "structcheck": {
-      "name": "Structure checks",
-      "cmd": "structcheck",
+    "go-test": {
+      "name": "go test",
+      "cmd": "go",
       "check": {
-        "args": ["./..."]
+        "args": ["test", "-json", "./..."]
+      },
+      "validate": {
+        "type": "gotest"
+      }
+    },
+    "gocyclo": {
+      "name": "cyclomatic check",
+      "cmd": "gocyclo",
+      "params": {
+        "over": 10
+      },
+      "check": {
+        "args": ["-over", "{{.over}}", "$1"]
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/opennota/check/cmd/structcheck"]
+        "args": ["install", "github.com/fzipp/gocyclo/cmd/gocyclo@latest"]
+      },
+      "each": {
+        "cmd": "git",
+        "args": ["ls-files", "--", "*.go"]
       }
     },
     "errcheck": {
@@ -122,7 +149,7 @@ var macros = `{
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/kisielk/errcheck"]
+        "args": ["install", "github.com/kisielk/errcheck@latest"]
       }
     },
     "ineffassign": {
@@ -133,7 +160,7 @@ var macros = `{
       },
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/gordonklaus/ineffassign"]
+        "args": ["install", "github.com/gordonklaus/ineffassign@latest"]
       }
     },
     "go-install": {
@@ -149,12 +176,11 @@ var macros = `{
       "cmd": "go",
       "godep": true,
       "gotool": "cover",
-      "install": {
-        "cmd": "go",
-        "args": ["get", "golang.org/x/tools/cmd/cover"]
+      "params": {
+        "timeout": "3s"
       },
       "check": {
-        "args": ["test", "-cover", "-covermode", "atomic", "-race", "-parallel=8", "-timeout", "3s", "-cpu", "4", "./..."]
+        "args": ["test", "-covermode", "atomic", "-coverprofile", "${coverprofile}", "-race", "-parallel=8", "-timeout", "{{.timeout}}", "-cpu", "4", "./..."]
       },
       "validate": {
         "type": "cover",
@@ -166,14 +192,83 @@ var macros = `{
       "cmd": "gocoverdir",
       "install": {
         "cmd": "go",
-        "args": ["get", "github.com/cep21/gocoverdir"]
+        "args": ["install", "github.com/cep21/gocoverdir@latest"]
+      },
+      "params": {
+        "timeout": "3s",
+        "coverage": 100
       },
       "check": {
-        "args": ["-race", "-timeout", "3s", "-cpu", "4", "-requiredcoverage", "100"]
+        "args": ["-race", "-timeout", "{{.timeout}}", "-cpu", "4", "-requiredcoverage", "{{.coverage}}"]
       },
       "validate": {
         "type": "returncode"
       }
+    },
+    "deprecated/vet": {
+      "name": "vet",
+      "cmd": "go",
+      "deprecated": "go tool vet was removed from go: use go-vet",
+      "check": {
+        "args": ["tool", "vet", "$1"]
+      },
+      "gotool": "vet",
+      "each": {
+        "cmd": "git",
+        "args": ["ls-files", "--", "*.go"]
+      }
+    },
+    "deprecated/golint": {
+      "name": "code lint",
+      "cmd": "golint",
+      "deprecated": "golint is deprecated: use staticcheck",
+      "check": {
+        "args": ["-min_confidence=.3", "$1"]
+      },
+      "install": {
+        "cmd": "go",
+        "args": ["install", "golang.org/x/lint/golint@latest"]
+      },
+      "each": {
+        "cmd": "git",
+        "args": ["ls-files", "--", "*.go"]
+      }
+    },
+    "deprecated/varcheck": {
+      "name": "varcheck check",
+      "cmd": "varcheck",
+      "deprecated": "varcheck is archived: use staticcheck",
+      "check": {
+        "args": ["./..."]
+      },
+      "install": {
+        "cmd": "go",
+        "args": ["install", "github.com/opennota/check/cmd/varcheck@latest"]
+      }
+    },
+    "deprecated/aligncheck": {
+      "name": "alignment check",
+      "cmd": "aligncheck",
+      "deprecated": "aligncheck is archived: use go-vet",
+      "check": {
+        "args": ["./..."]
+      },
+      "install": {
+        "cmd": "go",
+        "args": ["install", "github.com/opennota/check/cmd/aligncheck@latest"]
+      }
+    },
+    "deprecated/structcheck": {
+      "name": "Structure checks",
+      "cmd": "structcheck",
+      "deprecated": "structcheck is archived: use staticcheck",
+      "check": {
+        "args": ["./..."]
+      },
+      "install": {
+        "cmd": "go",
+        "args": ["install", "github.com/opennota/check/cmd/structcheck@latest"]
+      }
     }
   }
 }
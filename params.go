@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// mergeParams returns the parameters of p2, replaced by any also defined in p1
+func mergeParams(p1, p2 map[string]interface{}) map[string]interface{} {
+	if len(p1) == 0 {
+		return p2
+	}
+	if len(p2) == 0 {
+		return p1
+	}
+	ret := make(map[string]interface{}, len(p1)+len(p2))
+	for k, v := range p2 {
+		ret[k] = v
+	}
+	for k, v := range p1 {
+		ret[k] = v
+	}
+	return ret
+}
+
+func paramType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	}
+	return "object"
+}
+
+// paramValues returns the parameter defaults with the values from With applied.  Every value in With must be a
+// declared parameter of the same type as its default.
+func (c *check) paramValues() (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(c.Params))
+	for k, v := range c.Params {
+		values[k] = v
+	}
+	for k, v := range c.With {
+		def, exists := c.Params[k]
+		if !exists {
+			return nil, fmt.Errorf("check %s: unknown parameter %s", c.Name, k)
+		}
+		if def != nil && paramType(def) != paramType(v) {
+			return nil, fmt.Errorf("check %s: parameter %s must be a %s, not %s", c.Name, k, paramType(def), paramType(v))
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+func expandParam(s string, values map[string]interface{}) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New("arg").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	// JSON numbers are float64, which template prints in exponent form once they are large
+	formatted := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if f, ok := v.(float64); ok {
+			v = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		formatted[k] = v
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, formatted); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func expandParams(args []string, values map[string]interface{}) ([]string, error) {
+	if args == nil {
+		return nil, nil
+	}
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		if ret[i], err = expandParam(arg, values); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func expandCheckCmd(c *checkCmd, values map[string]interface{}) (*checkCmd, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cmd, err := expandParam(c.Cmd, values)
+	if err != nil {
+		return nil, err
+	}
+	args, err := expandParams(c.Args, values)
+	if err != nil {
+		return nil, err
+	}
+	return &checkCmd{
+		Cmd:  cmd,
+		Args: args,
+	}, nil
+}
+
+// applyParams substitutes {{.name}} parameter references in the commands and args of the check.  Commands are
+// copied, never modified in place, since they may be shared with the macro they came from.
+func (c *check) applyParams() error {
+	values, err := c.paramValues()
+	if err != nil {
+		return err
+	}
+	if c.Cmd, err = expandParam(c.Cmd, values); err != nil {
+		return fmt.Errorf("check %s: %s", c.Name, err)
+	}
+	for _, cmd := range []**checkCmd{&c.Fix, &c.Check, &c.Install} {
+		if *cmd, err = expandCheckCmd(*cmd, values); err != nil {
+			return fmt.Errorf("check %s: %s", c.Name, err)
+		}
+	}
+	then := make([]*checkCmd, len(c.Then))
+	for i := range c.Then {
+		if then[i], err = expandCheckCmd(c.Then[i], values); err != nil {
+			return fmt.Errorf("check %s: %s", c.Name, err)
+		}
+	}
+	c.Then = then
+	if c.Each != nil {
+		each := *c.Each
+		if each.Args, err = expandParams(each.Args, values); err != nil {
+			return fmt.Errorf("check %s: %s", c.Name, err)
+		}
+		c.Each = &each
+	}
+	return nil
+}
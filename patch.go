@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// patchCoverage requires coverage of the lines changed since a git ref, so new code is tested even if the rest
+// of the repository is not
+type patchCoverage struct {
+	// Base is the git ref to diff against, like origin/main
+	Base string `json:"base"`
+	// Coverage is the percent of changed executable lines that must be covered
+	Coverage float64 `json:"coverage"`
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// parseDiffLines returns the lines added or changed in each file of a `git diff -U0`
+func parseDiffLines(diff string) (map[string][]int, error) {
+	ret := make(map[string][]int)
+	file := ""
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			}
+		case strings.HasPrefix(line, "@@ ") && file != "":
+			matches := hunkHeader.FindStringSubmatch(line)
+			if matches == nil {
+				return nil, fmt.Errorf("unable to parse diff hunk %q", line)
+			}
+			start, err := strconv.Atoi(matches[1])
+			if err != nil {
+				return nil, err
+			}
+			count := 1
+			if matches[2] != "" {
+				if count, err = strconv.Atoi(matches[2]); err != nil {
+					return nil, err
+				}
+			}
+			for i := start; i < start+count; i++ {
+				ret[file] = append(ret[file], i)
+			}
+		}
+	}
+	return ret, nil
+}
+
+type patchCoverageError struct {
+	covered   int
+	changed   int
+	required  float64
+	uncovered []string
+}
+
+func (p *patchCoverageError) percent() float64 {
+	return 100 * float64(p.covered) / float64(p.changed)
+}
+
+func (p *patchCoverageError) Error() string {
+	msg := fmt.Sprintf("Patch coverage %f (%d of %d changed lines) less than required %f", p.percent(), p.covered, p.changed, p.required)
+	for _, line := range p.uncovered {
+		msg += "\n  " + line
+	}
+	return msg
+}
+
+// lineRanges formats sorted lines of file as file:line, with runs of lines as file:first-last
+func lineRanges(file string, lines []int) []string {
+	var ret []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			ret = append(ret, fmt.Sprintf("%s:%d", file, lines[i]))
+		} else {
+			ret = append(ret, fmt.Sprintf("%s:%d-%d", file, lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return ret
+}
+
+// checkPatch checks the coverage of the changed lines.  A changed line is executable if a block of the profile
+// includes it, and covered if every block including it ran.
+func (c *coverageValidator) checkPatch(blocks []coverBlock) error {
+	byFile, _ := blocksByFile(blocks)
+	// The diff is of paths on disk, relative to the check's directory
+	bySource := make(map[string][]coverBlock, len(byFile))
+	for file, fileBlocks := range byFile {
+		bySource[c.sourceFile(file)] = fileBlocks
+	}
+	diffFiles := make([]string, 0, len(c.changedLines))
+	for file := range c.changedLines {
+		diffFiles = append(diffFiles, file)
+	}
+	sort.Strings(diffFiles)
+	ret := &patchCoverageError{
+		required: c.Patch.Coverage,
+	}
+	for _, diffFile := range diffFiles {
+		hits := lineHits(bySource[diffFile])
+		var uncovered []int
+		for _, line := range c.changedLines[diffFile] {
+			count, executable := hits[line]
+			if !executable {
+				continue
+			}
+			ret.changed++
+			if count > 0 {
+				ret.covered++
+			} else {
+				uncovered = append(uncovered, line)
+			}
+		}
+		ret.uncovered = append(ret.uncovered, lineRanges(diffFile, uncovered)...)
+	}
+	if ret.changed == 0 || ret.percent()+.009 > c.Patch.Coverage {
+		return nil
+	}
+	return ret
+}
+
+// changedLines returns the lines changed since base, by file relative to the check's directory
+func (p *goverify) changedLines(c check, base string) (map[string][]int, error) {
+	if base == "" {
+		return nil, fmt.Errorf("patch coverage needs a base ref to diff against")
+	}
+	diff, err := p.gitOutput(c, "diff", "--relative", "--unified=0", "--no-color", base, "--", "*.go")
+	if err != nil {
+		return nil, err
+	}
+	return parseDiffLines(diff)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profile picks the checks to run for one context, like precommit or ci, and changes their settings
+type profile struct {
+	// Extends is a profile this one starts from.  Checks are added to its checks, and overrides and
+	// simultaneousRuns replace its own.
+	Extends string `json:"extends"`
+	// Checks are the names, or macros, of the checks to run.  Every check runs if no profile in the chain lists any.
+	Checks []string `json:"checks"`
+	// Overrides change checks by name, or macro, the same way a nested config does
+	Overrides        map[string]check `json:"overrides"`
+	SimultaneousRuns int              `json:"simultaneousRuns"`
+}
+
+// selectedProfile is a profile with the profiles it extends merged in
+type selectedProfile struct {
+	name string
+	// checks is nil if every check runs
+	checks    map[string]bool
+	overrides map[string]check
+	// sources are the profiles each override came from
+	sources          map[string]string
+	simultaneousRuns int
+}
+
+func profileNames(conf *config) string {
+	names := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// selectProfile merges the profile name with the profiles it extends
+func selectProfile(conf *config, name string) (*selectedProfile, error) {
+	var chain []string
+	for current := name; current != ""; current = conf.Profiles[current].Extends {
+		if _, exists := conf.Profiles[current]; !exists {
+			if current == name {
+				return nil, fmt.Errorf("unknown profile %s: expected one of %s", name, profileNames(conf))
+			}
+			return nil, fmt.Errorf("profile %s extends unknown profile %s", chain[len(chain)-1], current)
+		}
+		for i, seen := range chain {
+			if seen == current {
+				return nil, fmt.Errorf("profile cycle: %s -> %s", strings.Join(chain[i:], " -> "), current)
+			}
+		}
+		chain = append(chain, current)
+	}
+	ret := &selectedProfile{
+		name:      name,
+		overrides: make(map[string]check),
+		sources:   make(map[string]string),
+	}
+	// Apply the base profile first, so the profiles extending it win
+	for i := len(chain) - 1; i >= 0; i-- {
+		prof := conf.Profiles[chain[i]]
+		if len(prof.Checks) > 0 && ret.checks == nil {
+			ret.checks = make(map[string]bool)
+		}
+		for _, id := range prof.Checks {
+			ret.checks[id] = true
+		}
+		for id, override := range prof.Overrides {
+			if parent, exists := ret.overrides[id]; exists {
+				override = overrideCheck(parent, override)
+				ret.sources[id] += ", edited by profile " + chain[i]
+			} else {
+				ret.sources[id] = "profile " + chain[i]
+			}
+			ret.overrides[id] = override
+		}
+		if prof.SimultaneousRuns != 0 {
+			ret.simultaneousRuns = prof.SimultaneousRuns
+		}
+	}
+	return ret, nil
+}
+
+// checkIDs returns the checkID of every check in the root and nested configs
+func checkIDs(conf *config) map[string]bool {
+	ids := make(map[string]bool)
+	for _, c := range conf.Checks {
+		ids[checkID(c)] = true
+	}
+	for _, nested := range conf.nested {
+		for _, c := range nested.checks {
+			ids[checkID(c)] = true
+		}
+	}
+	return ids
+}
+
+// applyProfile selects the profile name, or the config's default profile if name is empty.  Checks are limited
+// to the profile by scopedChecks.
+func applyProfile(conf *config, name string) error {
+	name = nonEmptyStr(name, conf.DefaultProfile)
+	if name == "" {
+		return nil
+	}
+	prof, err := selectProfile(conf, name)
+	if err != nil {
+		return err
+	}
+	ids := checkIDs(conf)
+	for id := range prof.checks {
+		if !ids[id] {
+			return fmt.Errorf("profile %s: unknown check %s", name, id)
+		}
+	}
+	for id := range prof.overrides {
+		if !ids[id] {
+			return fmt.Errorf("profile %s: override of unknown check %s", name, id)
+		}
+	}
+	if prof.simultaneousRuns != 0 {
+		conf.SimultaneousRuns = prof.simultaneousRuns
+	}
+	conf.profile = prof
+	return nil
+}
+
+// includes returns true if the check with checkID id runs in the profile
+func (s *selectedProfile) includes(id string) bool {
+	return s == nil || s.checks == nil || s.checks[id]
+}
+
+// override returns the profile's changes to the check with checkID id
+func (s *selectedProfile) override(id string) (check, bool) {
+	if s == nil {
+		return check{}, false
+	}
+	c, exists := s.overrides[id]
+	return c, exists
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// finding is one problem a validator found in a check's output
+type finding struct {
+	severity string
+	// stream is stdout or stderr
+	stream string
+	// rule is the pattern that matched
+	rule string
+	line string
+	// file, relative to the root config, and fileLine are where the finding points, if known
+	file     string
+	fileLine int
+}
+
+func (f finding) String() string {
+	return fmt.Sprintf("%s: %s", f.severity, f.line)
+}
+
+// findingsValidator is a validator that reports each problem in the output, rather than only pass or fail.  Only
+// findings with severity error fail the check.
+type findingsValidator interface {
+	cmdValidator
+	Findings(stdout *bytes.Buffer, stderr *bytes.Buffer) ([]finding, error)
+}
+
+type findingsError struct {
+	errors int
+}
+
+func (f *findingsError) Error() string {
+	if f.errors == 1 {
+		return "1 error in output"
+	}
+	return fmt.Sprintf("%d errors in output", f.errors)
+}
+
+// checkFindings returns an error if any finding has severity error
+func checkFindings(findings []finding) error {
+	errCount := 0
+	for _, f := range findings {
+		if f.severity == severityError {
+			errCount++
+		}
+	}
+	if errCount > 0 {
+		return &findingsError{errors: errCount}
+	}
+	return nil
+}
+
+func formatFindings(findings []finding) string {
+	lines := make([]string, 0, len(findings))
+	for _, f := range findings {
+		lines = append(lines, f.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// regexPatterns are the patterns for one output stream.  A line matching ignore is skipped, then one matching
+// failOn is an error and one matching warnOn is a warning.  Other lines are allowed.
+type regexPatterns struct {
+	FailOn []string `json:"failOn"`
+	WarnOn []string `json:"warnOn"`
+	Ignore []string `json:"ignore"`
+
+	// The patterns are compiled once, since each file of a check may be validated at the same time
+	compileOnce sync.Once
+	compileErr  error
+	failOn      []*regexp.Regexp
+	warnOn      []*regexp.Regexp
+	ignore      []*regexp.Regexp
+}
+
+func compilePatterns(stream string, field string, patterns []string) ([]*regexp.Regexp, error) {
+	ret := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s pattern %q: %s", stream, field, pattern, err)
+		}
+		ret = append(ret, re)
+	}
+	return ret, nil
+}
+
+func firstMatch(res []*regexp.Regexp, line string) *regexp.Regexp {
+	for _, re := range res {
+		if re.MatchString(line) {
+			return re
+		}
+	}
+	return nil
+}
+
+func (r *regexPatterns) compile(stream string) error {
+	r.compileOnce.Do(func() {
+		if r.ignore, r.compileErr = compilePatterns(stream, "ignore", r.Ignore); r.compileErr != nil {
+			return
+		}
+		if r.failOn, r.compileErr = compilePatterns(stream, "failOn", r.FailOn); r.compileErr != nil {
+			return
+		}
+		r.warnOn, r.compileErr = compilePatterns(stream, "warnOn", r.WarnOn)
+	})
+	return r.compileErr
+}
+
+// checkRegexPatterns reports the patterns of a regex validator that do not compile
+func (v *configValidation) checkRegexPatterns(n *configNode, where string) {
+	for _, stream := range []string{"stdout", "stderr"} {
+		for _, field := range []string{"failOn", "warnOn", "ignore"} {
+			for i, item := range n.field(stream).field(field).itemsOrNil() {
+				pattern, ok := item.value.(string)
+				if !ok {
+					continue
+				}
+				if _, err := regexp.Compile(pattern); err != nil {
+					v.errorf(item, "%s.%s.%s[%d]: invalid pattern %q: %s", where, stream, field, i, pattern, err)
+				}
+			}
+		}
+	}
+}
+
+func (r *regexPatterns) findings(stream string, output *bytes.Buffer) ([]finding, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if err := r.compile(stream); err != nil {
+		return nil, err
+	}
+	var ret []finding
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.TrimSpace(line) == "" || firstMatch(r.ignore, line) != nil {
+			continue
+		}
+		if re := firstMatch(r.failOn, line); re != nil {
+			ret = append(ret, finding{severity: severityError, stream: stream, rule: re.String(), line: line})
+		} else if re := firstMatch(r.warnOn, line); re != nil {
+			ret = append(ret, finding{severity: severityWarning, stream: stream, rule: re.String(), line: line})
+		}
+	}
+	return ret, nil
+}
+
+// regexValidator finds errors and warnings in the output of a check by regular expression
+type regexValidator struct {
+	validator
+	Stdout *regexPatterns `json:"stdout"`
+	Stderr *regexPatterns `json:"stderr"`
+}
+
+func (c *regexValidator) MergePropertiesFrom(val json.RawMessage) {
+	if val == nil {
+		return
+	}
+	var other regexValidator
+	if err := json.Unmarshal(val, &other); err != nil {
+		return
+	}
+	if other.Stdout != nil {
+		c.Stdout = other.Stdout
+	}
+	if other.Stderr != nil {
+		c.Stderr = other.Stderr
+	}
+}
+
+func (c *regexValidator) Findings(stdout *bytes.Buffer, stderr *bytes.Buffer) ([]finding, error) {
+	stdoutFindings, err := c.Stdout.findings("stdout", stdout)
+	if err != nil {
+		return nil, err
+	}
+	stderrFindings, err := c.Stderr.findings("stderr", stderr)
+	if err != nil {
+		return nil, err
+	}
+	return append(stdoutFindings, stderrFindings...), nil
+}
+
+func (c *regexValidator) Check(stdout *bytes.Buffer, stderr *bytes.Buffer) error {
+	findings, err := c.Findings(stdout, stderr)
+	if err != nil {
+		return err
+	}
+	return checkFindings(findings)
+}
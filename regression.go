@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// coverageBaseline is the coverage a cover validator compares against, to catch coverage that slowly drops
+type coverageBaseline struct {
+	// File is a coverprofile, or a JSON coverageSnapshot, relative to the check's directory
+	File string `json:"file"`
+	// Ref is a git ref to run the check at for a coverprofile to compare against, like origin/main
+	Ref string `json:"ref"`
+	// MaxDrop is how many percent the total, or a package's, coverage may drop from the baseline
+	MaxDrop float64 `json:"maxDrop"`
+}
+
+// coverageSnapshot is the coverage of each package, and in total, that a baseline file may contain
+type coverageSnapshot struct {
+	Total    float64            `json:"total"`
+	Packages map[string]float64 `json:"packages"`
+}
+
+func snapshotOf(summary coverageSummary) coverageSnapshot {
+	s := coverageSnapshot{
+		Total:    summary.total.percent(),
+		Packages: make(map[string]float64, len(summary.packages)),
+	}
+	for pkg, counts := range summary.packages {
+		s.Packages[pkg] = counts.percent()
+	}
+	return s
+}
+
+// baselineSnapshot returns the baseline coverage, from profile if the baseline is a git ref, or from the
+// baseline file
+func (c *coverageValidator) baselineSnapshot(profile []byte) (coverageSnapshot, error) {
+	if profile == nil {
+		filename := c.Baseline.File
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(c.dir, filepath.FromSlash(filename))
+		}
+		var err error
+		if profile, err = ioutil.ReadFile(filename); err != nil {
+			return coverageSnapshot{}, fmt.Errorf("unable to read coverage baseline: %s", err)
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(profile), []byte("{")) {
+			var s coverageSnapshot
+			if err = json.Unmarshal(profile, &s); err != nil {
+				return s, fmt.Errorf("unable to load coverage baseline %s: %s", filename, err)
+			}
+			return s, nil
+		}
+	}
+	blocks, err := parseCoverProfile(profile)
+	if err != nil {
+		return coverageSnapshot{}, err
+	}
+	return snapshotOf(summarizeCoverage(c.checkedBlocks(blocks))), nil
+}
+
+// coverageRegression is coverage that dropped too far from the baseline
+type coverageRegression struct {
+	drops []string
+	table string
+}
+
+func (c *coverageRegression) Error() string {
+	return strings.Join(c.drops, "\n") + "\n" + c.table
+}
+
+// compareCoverage returns an error if the total, or a package's, coverage in current dropped more than maxDrop
+// from base, along with a table of the change in coverage of each package
+func compareCoverage(base coverageSnapshot, current coverageSnapshot, maxDrop float64) (*coverageRegression, string) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Package\tBase\tNow\tDelta\t\n")
+	var drops []string
+	pkgs := make([]string, 0, len(current.Packages))
+	for pkg := range current.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	for pkg := range base.Packages {
+		if _, exists := current.Packages[pkg]; !exists {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Strings(pkgs)
+	row := func(name string, before float64, hadBefore bool, now float64, hasNow bool) {
+		cells := []string{name, "-", "-", "-"}
+		if hadBefore {
+			cells[1] = fmt.Sprintf("%.1f%%", before)
+		}
+		if hasNow {
+			cells[2] = fmt.Sprintf("%.1f%%", now)
+		}
+		if hadBefore && hasNow {
+			cells[3] = fmt.Sprintf("%+.1f%%", now-before)
+			if before-now > maxDrop+.009 {
+				drops = append(drops, fmt.Sprintf("Coverage of %s dropped from %f to %f, more than %f", name, before, now, maxDrop))
+			}
+		}
+		fmt.Fprintf(w, "%s\t\n", strings.Join(cells, "\t"))
+	}
+	for _, pkg := range pkgs {
+		before, hadBefore := base.Packages[pkg]
+		now, hasNow := current.Packages[pkg]
+		row(pkg, before, hadBefore, now, hasNow)
+	}
+	row("total", base.Total, true, current.Total, true)
+	if err := w.Flush(); err != nil {
+		drops = append(drops, err.Error())
+	}
+	if len(drops) == 0 {
+		return nil, buf.String()
+	}
+	return &coverageRegression{
+		drops: drops,
+		table: buf.String(),
+	}, buf.String()
+}
+
+// gitOutput runs git in the check's directory and returns its trimmed output
+func (p *goverify) gitOutput(c check, args ...string) (string, error) {
+	cmd := c.command("git", args...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		return "", &checkResult{
+			checkName:   "git " + strings.Join(args, " "),
+			output:      stdout.String() + stderr.String(),
+			originalErr: err,
+		}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// profileAtRef runs toRun in a git worktree of ref, and returns the coverprofile it writes
+func (p *goverify) profileAtRef(c check, toRun *checkCmd, param string, ref string) ([]byte, error) {
+	top, err := p.gitOutput(c, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(top, c.workDir)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir, err := ioutil.TempDir("", "goverify")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			p.logger.Printf("Unable to remove %s: %s", tmpDir, err)
+		}
+	}()
+	worktree := filepath.Join(tmpDir, "worktree")
+	if _, err = p.gitOutput(c, "worktree", "add", "--detach", worktree, ref); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := p.gitOutput(c, "worktree", "remove", "--force", worktree); err != nil {
+			p.logger.Printf("Unable to remove worktree %s: %s", worktree, err)
+		}
+	}()
+	atRef := c
+	atRef.workDir = filepath.Join(worktree, rel)
+	cmdToRun, args := atRef.commandLine(toRun, param)
+	profile, err := replaceCoverProfile(args)
+	if err != nil {
+		return nil, err
+	}
+	if profile == "" {
+		return nil, fmt.Errorf("check does not write a coverprofile to %s to compare with %s", coverProfileVar, ref)
+	}
+	defer func() {
+		if err := os.RemoveAll(filepath.Dir(profile)); err != nil {
+			p.logger.Printf("Unable to remove coverprofile %s: %s", profile, err)
+		}
+	}()
+	p.logger.Printf("Running command %s %s at %s\n", cmdToRun, args, ref)
+	cmd := atRef.command(cmdToRun, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err = p.run(cmd); err != nil {
+		return nil, &checkResult{
+			checkName:   "coverage at " + ref,
+			output:      output.String(),
+			originalErr: err,
+		}
+	}
+	return ioutil.ReadFile(profile)
+}
+
+// coverageInputs returns a copy of the validator with what it needs from outside the check's own run: the
+// coverprofile of the baseline ref and the lines changed since the patch base
+func (p *goverify) coverageInputs(c check, cover *coverageValidator, toRun *checkCmd, param string) (*coverageValidator, error) {
+	withInputs := *cover
+	var err error
+	if cover.Baseline != nil && cover.Baseline.Ref != "" {
+		if withInputs.baseProfile, err = p.profileAtRef(c, toRun, param, cover.Baseline.Ref); err != nil {
+			return nil, err
+		}
+	}
+	if cover.Patch != nil {
+		if withInputs.changedLines, err = p.changedLines(c, cover.Patch.Base); err != nil {
+			return nil, err
+		}
+	}
+	return &withInputs, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lineHits returns how many times each line of the blocks of one file ran.  A line in more than one block ran as
+// many times as the least run of them, so it only counts as covered if all of them ran.
+func lineHits(blocks []coverBlock) map[int]int {
+	hits := make(map[int]int)
+	for _, b := range blocks {
+		for line := b.startLine; line <= b.endLine; line++ {
+			if count, exists := hits[line]; !exists || b.count < count {
+				hits[line] = b.count
+			}
+		}
+	}
+	return hits
+}
+
+func blocksByFile(blocks []coverBlock) (map[string][]coverBlock, []string) {
+	byFile := make(map[string][]coverBlock)
+	var files []string
+	for _, b := range blocks {
+		if _, exists := byFile[b.file]; !exists {
+			files = append(files, b.file)
+		}
+		byFile[b.file] = append(byFile[b.file], b)
+	}
+	sort.Strings(files)
+	return byFile, files
+}
+
+// moduleOf returns the directory and module path of the go.mod in dir or the closest directory above it
+func moduleOf(dir string) (string, string) {
+	for {
+		if goMod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			if matches := modulePattern.FindSubmatch(goMod); matches != nil {
+				return dir, string(matches[1])
+			}
+			return "", ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// sourceFile returns the path on disk, relative to the check's directory, of a file of the coverprofile
+func (c *coverageValidator) sourceFile(file string) string {
+	if modDir, module := moduleOf(c.dir); module != "" && strings.HasPrefix(file, module+"/") {
+		onDisk := filepath.Join(modDir, filepath.FromSlash(strings.TrimPrefix(file, module+"/")))
+		if rel, err := filepath.Rel(nonEmptyStr(c.dir, "."), onDisk); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	// Without a go.mod, find the longest end of the import path that exists
+	parts := strings.Split(file, "/")
+	for i := range parts {
+		rel := strings.Join(parts[i:], "/")
+		if _, err := ioutil.ReadFile(filepath.Join(c.dir, filepath.FromSlash(rel))); err == nil {
+			return rel
+		}
+	}
+	return file
+}
+
+func (c *coverageValidator) reportPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, filepath.FromSlash(p))
+}
+
+// writeReports writes the coverage reports the validator is configured with
+func (c *coverageValidator) writeReports(blocks []coverBlock) error {
+	if c.HTML != "" {
+		out, err := c.htmlReport(blocks)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(c.reportPath(c.HTML), out, 0644); err != nil {
+			return err
+		}
+	}
+	if c.Cobertura != "" {
+		out, err := c.coberturaReport(blocks, time.Now())
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(c.reportPath(c.Cobertura), out, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var htmlReportTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { background: black; color: rgb(80, 80, 80); font-family: Menlo, monospace; }
+#nav { padding: 8px; }
+.cov0 { color: rgb(192, 0, 0); }
+.cov1 { color: rgb(44, 212, 149); }
+pre { display: none; }
+</style>
+</head>
+<body>
+<div id="nav">
+<select id="files" onchange="show(this.value)">
+{{range $i, $f := .Files}}<option value="file{{$i}}">{{$f.Name}} ({{printf "%.1f" $f.Percent}}%)</option>
+{{end}}</select>
+total: {{printf "%.1f" .Total}}%
+</div>
+{{range $i, $f := .Files}}<pre id="file{{$i}}">{{$f.Body}}</pre>
+{{end}}<script>
+function show(id) {
+	var files = document.getElementsByTagName("pre");
+	for (var i = 0; i < files.length; i++) {
+		files[i].style.display = files[i].id === id ? "block" : "none";
+	}
+}
+show("file0");
+</script>
+</body>
+</html>
+`))
+
+type htmlReportFile struct {
+	Name    string
+	Percent float64
+	Body    template.HTML
+}
+
+// htmlSource marks up the source of a file with the blocks that ran and those that did not, like
+// `go tool cover -html`
+func htmlSource(src []byte, blocks []coverBlock) template.HTML {
+	type boundary struct {
+		offset  int
+		start   bool
+		covered bool
+	}
+	lineStarts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offset := func(line, col int) int {
+		if line < 1 || line > len(lineStarts) {
+			return len(src)
+		}
+		if o := lineStarts[line-1] + col - 1; o < len(src) {
+			return o
+		}
+		return len(src)
+	}
+	var boundaries []boundary
+	for _, b := range blocks {
+		boundaries = append(boundaries,
+			boundary{offset: offset(b.startLine, b.startCol), start: true, covered: b.count > 0},
+			boundary{offset: offset(b.endLine, b.endCol)})
+	}
+	sort.SliceStable(boundaries, func(i, j int) bool {
+		if boundaries[i].offset != boundaries[j].offset {
+			return boundaries[i].offset < boundaries[j].offset
+		}
+		// Close a block before opening the next one at the same place
+		return !boundaries[i].start && boundaries[j].start
+	})
+	var buf bytes.Buffer
+	last := 0
+	for _, b := range boundaries {
+		template.HTMLEscape(&buf, src[last:b.offset])
+		last = b.offset
+		switch {
+		case !b.start:
+			buf.WriteString("</span>")
+		case b.covered:
+			buf.WriteString(`<span class="cov1">`)
+		default:
+			buf.WriteString(`<span class="cov0">`)
+		}
+	}
+	template.HTMLEscape(&buf, src[last:])
+	return template.HTML(buf.String())
+}
+
+// htmlReport is one HTML page of the source of every file, marked with coverage
+func (c *coverageValidator) htmlReport(blocks []coverBlock) ([]byte, error) {
+	byFile, files := blocksByFile(blocks)
+	summary := summarizeCoverage(blocks)
+	data := struct {
+		Files []htmlReportFile
+		Total float64
+	}{
+		Total: summary.total.percent(),
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(filepath.Join(c.dir, filepath.FromSlash(c.sourceFile(file))))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read source for coverage report: %s", err)
+		}
+		data.Files = append(data.Files, htmlReportFile{
+			Name:    file,
+			Percent: summary.files[file].percent(),
+			Body:    htmlSource(src, byFile[file]),
+		})
+	}
+	var buf bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Cobertura XML, as read by CI coverage widgets.  Cobertura counts lines, not statements.
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      float64            `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity float64          `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity float64         `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+func lineRate(covered, valid int) float64 {
+	if valid == 0 {
+		return 1
+	}
+	return float64(covered) / float64(valid)
+}
+
+// coberturaReport is the coverage as Cobertura XML, with a class for each file
+func (c *coverageValidator) coberturaReport(blocks []coverBlock, now time.Time) ([]byte, error) {
+	byFile, files := blocksByFile(blocks)
+	report := coberturaCoverage{
+		Timestamp: now.Unix(),
+		Sources:   []string{c.dir},
+	}
+	packageIndex := make(map[string]int)
+	packageCounts := make(map[string][2]int)
+	for _, file := range files {
+		pkg := path.Dir(file)
+		if _, exists := packageIndex[pkg]; !exists {
+			packageIndex[pkg] = len(report.Packages)
+			report.Packages = append(report.Packages, coberturaPackage{Name: pkg})
+		}
+		hits := lineHits(byFile[file])
+		lines := make([]int, 0, len(hits))
+		for line := range hits {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		class := coberturaClass{
+			Name:     path.Base(file),
+			Filename: c.sourceFile(file),
+		}
+		covered := 0
+		for _, line := range lines {
+			class.Lines = append(class.Lines, coberturaLine{Number: line, Hits: hits[line]})
+			if hits[line] > 0 {
+				covered++
+			}
+		}
+		class.LineRate = lineRate(covered, len(lines))
+		counts := packageCounts[pkg]
+		packageCounts[pkg] = [2]int{counts[0] + covered, counts[1] + len(lines)}
+		report.LinesCovered += covered
+		report.LinesValid += len(lines)
+		i := packageIndex[pkg]
+		report.Packages[i].Classes = append(report.Packages[i].Classes, class)
+	}
+	for i := range report.Packages {
+		counts := packageCounts[report.Packages[i].Name]
+		report.Packages[i].LineRate = lineRate(counts[0], counts[1])
+	}
+	report.LineRate = lineRate(report.LinesCovered, report.LinesValid)
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"), append(out, '\n')...), nil
+}
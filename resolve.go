@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolvedConfig is the effective config printed by `goverify config resolve`
+type resolvedConfig struct {
+	Config           string          `json:"config" yaml:"config"`
+	Profile          string          `json:"profile,omitempty" yaml:"profile,omitempty"`
+	SimultaneousRuns int             `json:"simultaneousRuns" yaml:"simultaneousRuns"`
+	IgnoreDir        []string        `json:"ignoreDir" yaml:"ignoreDir"`
+	Checks           []resolvedCheck `json:"checks" yaml:"checks"`
+}
+
+// resolvedCheck is a check after macro merging.  Sources says where each property came from.
+type resolvedCheck struct {
+	Name      string                 `json:"name" yaml:"name"`
+	Macro     string                 `json:"macro,omitempty" yaml:"macro,omitempty"`
+	Scope     string                 `json:"scope" yaml:"scope"`
+	Disabled  bool                   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Severity  string                 `json:"severity" yaml:"severity"`
+	Dir       string                 `json:"dir" yaml:"dir"`
+	Env       map[string]string      `json:"env,omitempty" yaml:"env,omitempty"`
+	When      *condition             `json:"when,omitempty" yaml:"when,omitempty"`
+	Check     string                 `json:"check" yaml:"check"`
+	Fix       string                 `json:"fix,omitempty" yaml:"fix,omitempty"`
+	Then      []string               `json:"then,omitempty" yaml:"then,omitempty"`
+	Install   string                 `json:"install,omitempty" yaml:"install,omitempty"`
+	Each      string                 `json:"each,omitempty" yaml:"each,omitempty"`
+	IgnoreDir []string               `json:"ignoreDir,omitempty" yaml:"ignoreDir,omitempty"`
+	Params    map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+	Validate  map[string]interface{} `json:"validate" yaml:"validate"`
+	Sources   map[string]string      `json:"sources" yaml:"sources"`
+}
+
+// checkProperties are the properties a check can inherit from its macro, and whether a definition sets them
+var checkProperties = []struct {
+	name string
+	set  func(c *check) bool
+}{
+	{"name", func(c *check) bool { return c.Name != "" }},
+	{"cmd", func(c *check) bool { return c.Cmd != "" }},
+	{"check", func(c *check) bool { return c.Check != nil }},
+	{"fix", func(c *check) bool { return c.Fix != nil }},
+	{"install", func(c *check) bool { return c.Install != nil }},
+	{"then", func(c *check) bool { return len(c.Then) > 0 }},
+	{"gotool", func(c *check) bool { return c.Gotool != "" }},
+	{"modtool", func(c *check) bool { return c.Modtool != "" }},
+	{"godep", func(c *check) bool { return c.Godep != nil }},
+	{"each", func(c *check) bool { return c.Each != nil }},
+	{"dir", func(c *check) bool { return c.Dir != "" }},
+	{"env", func(c *check) bool { return len(c.Env) > 0 }},
+	{"when", func(c *check) bool { return c.When != nil }},
+	{"severity", func(c *check) bool { return c.Severity != "" }},
+}
+
+// editsOnly returns true if the definition of property only edits inherited args, rather than setting them
+func editsOnly(c *check, property string) bool {
+	switch property {
+	case "check":
+		return len(c.Check.Args) == 0 && !c.Check.argOps.empty()
+	case "fix":
+		return len(c.Fix.Args) == 0 && !c.Fix.argOps.empty()
+	case "install":
+		return len(c.Install.Args) == 0 && !c.Install.argOps.empty()
+	case "each":
+		return len(c.Each.Args) == 0 && !c.Each.argOps.empty()
+	}
+	return false
+}
+
+// definitionLevel is one of the definitions a check is merged from: the check itself, its macro, and the macros
+// that macro extends
+type definitionLevel struct {
+	source string
+	def    check
+}
+
+func definitionChain(conf *config, c check) []definitionLevel {
+	chain := append([]definitionLevel{}, c.definedBy...)
+	macroName := c.Macro
+	if macroName != "" {
+		macroName, _, _ = lookupMacro(conf, macroName)
+	}
+	for macroName != "" {
+		def, exists := conf.macroDefs[macroName]
+		if !exists {
+			break
+		}
+		chain = append(chain, definitionLevel{
+			source: fmt.Sprintf("macro %s (%s)", macroName, conf.macroSources[macroName]),
+			def:    def,
+		})
+		macroName = def.Extends
+	}
+	return chain
+}
+
+func propertySource(chain []definitionLevel, property string, set func(c *check) bool) string {
+	var editedBy []string
+	for _, level := range chain {
+		if !set(&level.def) {
+			continue
+		}
+		if editsOnly(&level.def, property) {
+			editedBy = append(editedBy, level.source)
+			continue
+		}
+		source := level.source
+		for i := len(editedBy) - 1; i >= 0; i-- {
+			source += ", edited by " + editedBy[i]
+		}
+		return source
+	}
+	if len(editedBy) > 0 {
+		return strings.Join(editedBy, ", ")
+	}
+	return ""
+}
+
+func validatorKeys(raw json.RawMessage) map[string]json.RawMessage {
+	var keys map[string]json.RawMessage
+	if raw != nil {
+		_ = json.Unmarshal(raw, &keys)
+	}
+	return keys
+}
+
+func formatCommandLine(cmd string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, part := range append([]string{cmd}, args...) {
+		if part == "" || strings.ContainsAny(part, " \t\n\"'") {
+			part = fmt.Sprintf("%q", part)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *goverify) resolveConfig(conf *config) (*resolvedConfig, error) {
+	ret := &resolvedConfig{
+		Config:           p.configFile,
+		SimultaneousRuns: conf.SimultaneousRuns,
+		IgnoreDir:        conf.IgnoreDir,
+	}
+	if conf.profile != nil {
+		ret.Profile = conf.profile.name
+	}
+	for _, c := range scopedChecks(conf) {
+		resolved, err := p.resolveCheck(conf, c)
+		if err != nil {
+			return nil, err
+		}
+		chain := definitionChain(conf, c)
+		rc := resolvedCheck{
+			Name:     resolved.Name,
+			Macro:    resolved.Macro,
+			Scope:    nonEmptyStr(c.scopeDir, "."),
+			Disabled: c.Disabled,
+			Severity: nonEmptyStr(resolved.Severity, severityError),
+			Dir:      resolved.workDir,
+			Env:      resolved.Env,
+			When:     resolved.When,
+			Sources:  make(map[string]string),
+		}
+		for _, property := range checkProperties {
+			if source := propertySource(chain, property.name, property.set); source != "" {
+				rc.Sources[property.name] = source
+			}
+		}
+		if resolved.Check != nil {
+			rc.Check = formatCommandLine(resolved.commandLine(resolved.Check, "$1"))
+		}
+		if resolved.Fix != nil {
+			rc.Fix = formatCommandLine(resolved.commandLine(resolved.Fix, "$1"))
+		}
+		for _, then := range resolved.Then {
+			rc.Then = append(rc.Then, formatCommandLine(resolved.commandLine(then, "$1")))
+		}
+		if resolved.Install != nil {
+			rc.Install = formatCommandLine(resolved.Install.Cmd, resolved.Install.Args)
+		}
+		if resolved.Each != nil {
+			rc.Each = formatCommandLine(resolved.Each.Cmd, resolved.Each.Args)
+			rc.IgnoreDir = resolved.Each.IgnoreDir
+			source := propertySource(chain, "each.ignoreDir", func(c *check) bool {
+				return c.Each != nil && len(c.Each.IgnoreDir) > 0
+			})
+			if len(conf.IgnoreDir) > 0 {
+				if source == "" {
+					source = "config ignoreDir"
+				} else {
+					source += ", plus config ignoreDir"
+				}
+			}
+			if source != "" {
+				rc.Sources["each.ignoreDir"] = source
+			}
+		}
+		if params, err := resolved.paramValues(); err == nil && len(params) > 0 {
+			rc.Params = params
+			for name := range params {
+				rc.Sources["params."+name] = propertySource(chain, "params", func(c *check) bool {
+					_, inWith := c.With[name]
+					_, inParams := c.Params[name]
+					return inWith || inParams
+				})
+			}
+		}
+		if err = p.resolveValidator(conf, resolved, chain, &rc); err != nil {
+			return nil, err
+		}
+		ret.Checks = append(ret.Checks, rc)
+	}
+	return ret, nil
+}
+
+func (p *goverify) resolveValidator(conf *config, resolved check, chain []definitionLevel, rc *resolvedCheck) error {
+	asJSON, err := json.Marshal(resolved.validateDecoded)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(asJSON, &rc.Validate); err != nil {
+		return err
+	}
+	for key := range rc.Validate {
+		source := "default"
+		for _, level := range chain {
+			if _, exists := validatorKeys(level.def.Validator)[key]; exists {
+				source = level.source
+				break
+			}
+		}
+		if _, isCover := resolved.validateDecoded.(*coverageValidator); isCover && key == "ignoreDir" && len(conf.IgnoreDir) > 0 {
+			source = "config ignoreDir"
+		}
+		rc.Sources["validate."+key] = source
+	}
+	return nil
+}
+
+func (p *goverify) printResolvedConfig(args []string) error {
+	flags := flag.NewFlagSet("config resolve", flag.ContinueOnError)
+	format := flags.String("format", "json", "Output format: json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	conf, err := p.loadConfig()
+	if err != nil {
+		return err
+	}
+	resolved, err := p.resolveConfig(conf)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	switch *format {
+	case "json":
+		out, err = json.MarshalIndent(resolved, "", "  ")
+	case "yaml":
+		out, err = yaml.Marshal(resolved)
+	default:
+		return fmt.Errorf("unknown format %s: expected json or yaml", *format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.out(), "%s\n", strings.TrimSpace(string(out)))
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// schemaDefinitions names the config types that get their own definition in the schema
+var schemaDefinitions = map[reflect.Type]string{
+	reflect.TypeOf(config{}):         "config",
+	reflect.TypeOf(check{}):          "check",
+	reflect.TypeOf(checkCmd{}):       "checkCmd",
+	reflect.TypeOf(eachFileLister{}): "eachFileLister",
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+// configSchema returns a JSON Schema for config files.  It is generated from the config types, the same way
+// strict validation reads them, so the two can not disagree.
+func configSchema() map[string]interface{} {
+	g := &schemaGenerator{
+		definitions: make(map[string]interface{}),
+	}
+	root := g.typeSchema(reflect.TypeOf(config{}))
+	g.definitions["validate"] = g.validateSchema()
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "https://github.com/cep21/goverify/goverify.schema.json",
+		"title":       "goverify config",
+		"allOf":       []interface{}{root},
+		"definitions": g.definitions,
+	}
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return map[string]interface{}{"$ref": "#/definitions/validate"}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		name, named := schemaDefinitions[t]
+		if !named {
+			return g.structSchema(t)
+		}
+		if _, exists := g.definitions[name]; !exists {
+			// Reserve the name first, so recursive types terminate
+			g.definitions[name] = nil
+			g.definitions[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64, reflect.Float32:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for name, fieldType := range jsonFields(t) {
+		properties[name] = g.typeSchema(fieldType)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// validateSchema allows the fields of any validator type.  A validator may leave out its type when it only
+// changes settings of the validator of its macro, so each type is an alternative rather than picked by type.
+func (g *schemaGenerator) validateSchema() map[string]interface{} {
+	typesOf := make(map[reflect.Type][]string)
+	for name, newValidator := range validatorTypes {
+		t := reflect.TypeOf(newValidator()).Elem()
+		typesOf[t] = append(typesOf[t], name)
+	}
+	var alternatives []interface{}
+	for t, names := range typesOf {
+		sort.Strings(names)
+		s := g.structSchema(t)
+		s["properties"].(map[string]interface{})["type"] = map[string]interface{}{"enum": names}
+		alternatives = append(alternatives, s)
+	}
+	sort.Slice(alternatives, func(i, j int) bool {
+		return fmt.Sprint(alternatives[i]) < fmt.Sprint(alternatives[j])
+	})
+	return map[string]interface{}{
+		"anyOf": alternatives,
+	}
+}
+
+func (p *goverify) printSchema(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments %s", args)
+	}
+	schema, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.out(), "%s\n", schema)
+	return err
+}
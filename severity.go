@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// severities are the valid check severities.  A check's failures are reported at its severity: only errors fail
+// the run, warnings count towards -max-warnings, and info is only printed.
+var severities = []string{severityError, severityWarning, severityInfo}
+
+// downgrade returns findings with every severity above s lowered to s
+func downgrade(findings []finding, s string) []finding {
+	rank := map[string]int{severityInfo: 0, severityWarning: 1, severityError: 2}
+	ret := make([]finding, 0, len(findings))
+	for _, f := range findings {
+		if rank[f.severity] > rank[s] {
+			f.severity = s
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
+
+// outputFindings makes each line of output of a failed result, from a validator that does not report findings,
+// an error finding
+func outputFindings(res checkResult) checkResult {
+	if res.originalErr == nil || len(res.findings) > 0 {
+		return res
+	}
+	for _, line := range strings.Split(res.output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			res.findings = append(res.findings, finding{severity: severityError, line: line})
+		}
+	}
+	return res
+}
+
+// applySeverity reports a failed result of a check with severity warning or info as findings of that severity,
+// so it no longer fails the run.  Each line of output is a finding, or the error if there is no output.
+func applySeverity(severity string, res checkResult) checkResult {
+	if severity == "" || severity == severityError {
+		return res
+	}
+	res = outputFindings(res)
+	if res.originalErr != nil && len(res.findings) == 0 {
+		res.findings = append(res.findings, finding{severity: severityError, line: res.originalErr.Error()})
+	}
+	res.findings = downgrade(res.findings, severity)
+	res.originalErr = nil
+	return res
+}
+
+// warningSummary counts the warnings of each check
+type warningSummary struct {
+	checks []string
+	counts map[string]int
+}
+
+func (w *warningSummary) add(check string, findings []finding) {
+	for _, f := range findings {
+		if f.severity != severityWarning {
+			continue
+		}
+		if w.counts == nil {
+			w.counts = make(map[string]int)
+		}
+		if _, exists := w.counts[check]; !exists {
+			w.checks = append(w.checks, check)
+		}
+		w.counts[check]++
+	}
+}
+
+func (w *warningSummary) total() int {
+	total := 0
+	for _, count := range w.counts {
+		total += count
+	}
+	return total
+}
+
+func (w *warningSummary) print(out io.Writer) {
+	if len(w.checks) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Warnings: %d\n", w.total())
+	for _, check := range w.checks {
+		fmt.Fprintf(out, "  %s: %d\n", check, w.counts[check])
+	}
+}
+
+// checkBudget returns an error if there are more warnings than maxWarnings.  A negative maxWarnings allows any
+// number.
+func (w *warningSummary) checkBudget(maxWarnings int) error {
+	if maxWarnings >= 0 && w.total() > maxWarnings {
+		return fmt.Errorf("%d warnings is more than -max-warnings %d", w.total(), maxWarnings)
+	}
+	return nil
+}
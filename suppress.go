@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// suppressionComment is `//goverify:ignore <check> <reason>`.  It suppresses the check's findings on its own line,
+// and on the line below it unless it follows code.
+var suppressionComment = regexp.MustCompile(`^//goverify:ignore\b[ \t]*(\S*)[ \t]*(.*)$`)
+
+type suppression struct {
+	// file is relative to the root config
+	file   string
+	line   int
+	check  string
+	reason string
+	// trailing is true if the comment follows code on its line
+	trailing bool
+	used     bool
+}
+
+func (s *suppression) String() string {
+	return fmt.Sprintf("%s:%d: //goverify:ignore %s", s.file, s.line, s.check)
+}
+
+// suppressions are the suppression comments of the go files, read the first time a finding points at each file,
+// or by scan at the end of the run
+type suppressions struct {
+	rootPath string
+	logf     func(string, ...interface{})
+	// byFile is keyed by file, relative to the root config
+	byFile map[string][]*suppression
+	// files are in the order they were read
+	files []string
+	// invalid are the suppressions without a check or reason
+	invalid []string
+}
+
+func newSuppressions(rootPath string, logf func(string, ...interface{})) *suppressions {
+	return &suppressions{
+		rootPath: rootPath,
+		logf:     logf,
+		byFile:   make(map[string][]*suppression),
+	}
+}
+
+// inFile returns the suppression comments of file, relative to the root config.  A file that can not be read has
+// none.
+func (s *suppressions) inFile(file string) []*suppression {
+	if ret, exists := s.byFile[file]; exists {
+		return ret
+	}
+	ret, invalid, err := readSuppressions(s.rootPath, file)
+	if err != nil {
+		s.logf("Not reading suppressions of %s: %s", file, err)
+	}
+	s.byFile[file] = ret
+	s.files = append(s.files, file)
+	s.invalid = append(s.invalid, invalid...)
+	return ret
+}
+
+// scanSuppressions reads the suppression comments of the go files in scope of the checks that ran, so that unused
+// and invalid ones are reported even in files without findings.  Hidden directories, those in ignoreDir and those
+// that can not be read are not searched.
+func (r *runReport) scanSuppressions(conf *config) error {
+	if r.suppressions == nil || len(r.ran) == 0 {
+		return nil
+	}
+	return filepath.Walk(conf.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == conf.rootPath {
+				return err
+			}
+			r.suppressions.logf("Not searching %s for suppressions: %s", path, err)
+			if info != nil && !info.IsDir() {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			if path != conf.rootPath && (strings.HasPrefix(info.Name(), ".") || containsStr(conf.IgnoreDir, info.Name())) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".go" {
+			return nil
+		}
+		rel, err := filepath.Rel(conf.rootPath, path)
+		if err != nil {
+			return err
+		}
+		if r.ranOn(filepath.ToSlash(rel), "") {
+			r.suppressions.inFile(filepath.ToSlash(rel))
+		}
+		return nil
+	})
+}
+
+// ranOn returns true if a check with the id, or any check if id is empty, ran on file
+func (r *runReport) ranOn(file string, id string) bool {
+	for ranID, checks := range r.ran {
+		if id != "" && ranID != id {
+			continue
+		}
+		for _, c := range checks {
+			if c.inScope(file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check returns the suppressions without a check or reason as an error
+func (s *suppressions) check() error {
+	if s == nil || len(s.invalid) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(s.invalid, "\n"))
+}
+
+// readSuppressions reads the suppression comments of file, relative to rootPath.  Suppressions without a check or
+// reason are returned as invalid.
+func readSuppressions(rootPath string, file string) ([]*suppression, []string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(rootPath, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, nil, err
+	}
+	var ret []*suppression
+	var invalid []string
+	fset := token.NewFileSet()
+	tokFile := fset.AddFile(file, -1, len(content))
+	var sc scanner.Scanner
+	sc.Init(tokFile, content, nil, scanner.ScanComments)
+	codeLine := 0
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.COMMENT {
+			if lit != "\n" {
+				codeLine = fset.Position(pos).Line
+			}
+			continue
+		}
+		matches := suppressionComment.FindStringSubmatch(lit)
+		if matches == nil {
+			continue
+		}
+		s := &suppression{
+			file:     file,
+			line:     fset.Position(pos).Line,
+			check:    matches[1],
+			reason:   strings.TrimSpace(matches[2]),
+			trailing: codeLine == fset.Position(pos).Line,
+		}
+		if s.check == "" || s.reason == "" {
+			invalid = append(invalid, fmt.Sprintf("%s:%d: //goverify:ignore needs a check and a reason", s.file, s.line))
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret, invalid, nil
+}
+
+// suppressionIDs are the names a suppression comment can use for the check: its name or its macro
+func suppressionIDs(c check) []string {
+	var ids []string
+	for _, id := range []string{c.Name, c.Macro, strings.TrimPrefix(c.Macro, deprecatedNamespace)} {
+		if id != "" && !containsStr(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// suppressedBy returns the suppression comment for the finding of a check with ids, if there is one
+func (s *suppressions) suppressedBy(ids []string, f finding) *suppression {
+	if f.file == "" || f.fileLine == 0 {
+		return nil
+	}
+	for _, sup := range s.inFile(f.file) {
+		if containsStr(ids, sup.check) && (sup.line == f.fileLine || (!sup.trailing && sup.line == f.fileLine-1)) {
+			return sup
+		}
+	}
+	return nil
+}
+
+// applySuppressions removes the findings of res that are suppressed by a comment.  The result only fails if errors
+// remain.
+func (r *runReport) applySuppressions(c check, res checkResult) checkResult {
+	if r.suppressions == nil {
+		return res
+	}
+	withFindings := outputFindings(res)
+	ids := suppressionIDs(c)
+	var kept []finding
+	suppressed := 0
+	for _, f := range withFindings.findings {
+		f = c.locate(f)
+		if s := r.suppressions.suppressedBy(ids, f); s != nil {
+			s.used = true
+			suppressed++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if suppressed == 0 {
+		return res
+	}
+	withFindings.findings = kept
+	if withFindings.originalErr != nil {
+		withFindings.originalErr = checkFindings(kept)
+		withFindings.output = formatFindings(kept)
+	}
+	return withFindings
+}
+
+// printUnusedSuppressions lists the suppressions of checks that ran on their files that did not suppress anything
+func (r *runReport) printUnusedSuppressions(out io.Writer) {
+	if r.suppressions == nil {
+		return
+	}
+	files := append([]string{}, r.suppressions.files...)
+	sort.Strings(files)
+	for _, file := range files {
+		for _, s := range r.suppressions.byFile[file] {
+			if !s.used && r.ranOn(s.file, s.check) {
+				fmt.Fprintf(out, "%s is unused\n", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// filePos is a 1 based line and column in a config file
+type filePos struct {
+	line int
+	col  int
+}
+
+// configNode is a value of a config file along with where it is in the file, so validation errors can point at
+// it.  Values of object fields are positioned at their key.
+type configNode struct {
+	pos    filePos
+	keys   []string
+	fields map[string]*configNode
+	items  []*configNode
+	value  interface{}
+}
+
+func (n *configNode) field(key string) *configNode {
+	if n == nil {
+		return nil
+	}
+	return n.fields[key]
+}
+
+func (n *configNode) addField(key string, child *configNode) {
+	if n.fields == nil {
+		n.fields = make(map[string]*configNode)
+	}
+	if _, exists := n.fields[key]; !exists {
+		n.keys = append(n.keys, key)
+	}
+	n.fields[key] = child
+}
+
+type configError struct {
+	filename string
+	pos      filePos
+	msg      string
+}
+
+func (c *configError) Error() string {
+	if c.pos.line == 0 {
+		return fmt.Sprintf("%s: %s", c.filename, c.msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", c.filename, c.pos.line, c.pos.col, c.msg)
+}
+
+// configErrors are every problem found in a config file
+type configErrors []*configError
+
+func (c configErrors) Error() string {
+	msgs := make([]string, 0, len(c))
+	for _, err := range c {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type configValidation struct {
+	filename string
+	errs     configErrors
+}
+
+func (v *configValidation) errorf(n *configNode, format string, args ...interface{}) {
+	var pos filePos
+	if n != nil {
+		pos = n.pos
+	}
+	v.errs = append(v.errs, &configError{
+		filename: v.filename,
+		pos:      pos,
+		msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidation) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].pos.line != v.errs[j].pos.line {
+			return v.errs[i].pos.line < v.errs[j].pos.line
+		}
+		return v.errs[i].pos.col < v.errs[j].pos.col
+	})
+	return v.errs
+}
+
+// validateConfig checks the config file for unknown fields, unknown macros and validator types, and checks that
+// could never run.  conf must already have its macros loaded.  For nested configs, inherited are the checks of
+// parent configs by checkID, which the nested checks may override.
+func validateConfig(filename string, content []byte, conf *config, inherited map[string]check) error {
+	root, err := parseConfigNodes(filename, content)
+	if err != nil {
+		return err
+	}
+	v := &configValidation{
+		filename: filename,
+	}
+	v.checkFields(root, reflect.TypeOf(config{}), "config")
+	if inherited != nil {
+		for _, key := range root.keysOrNil() {
+			if key != "checks" && key != "$schema" {
+				v.errorf(root.field(key), "nested configs may only contain checks, not %s", key)
+			}
+		}
+	}
+
+	checksNode := root.field("checks")
+	checkOffset := 0
+	if checksNode != nil {
+		checkOffset = len(conf.Checks) - len(checksNode.items)
+	}
+	for i, n := range checksNode.itemsOrNil() {
+		c := conf.Checks[checkOffset+i]
+		where := fmt.Sprintf("checks[%d]", i)
+		if parent, exists := inherited[checkID(c)]; exists {
+			c = overrideCheck(parent, c)
+		}
+		merged := c
+		validatorFrom := c
+		if c.Macro != "" {
+			_, macro, exists := lookupMacro(conf, c.Macro)
+			if !exists {
+				v.errorf(n.field("macro"), "%s: unknown macro %s", where, c.Macro)
+				continue
+			}
+			merged.mergePropertiesFrom(macro)
+			if validatorType(c.Validator) == "" {
+				validatorFrom = macro
+			}
+		}
+		merged.flattenArgOps()
+		if merged.Cmd == "" && merged.Modtool == "" {
+			v.errorf(n, "%s: check has no cmd", where)
+		}
+		if merged.Check == nil || len(merged.Check.Args) == 0 {
+			v.errorf(n, "%s: check has no check args", where)
+		}
+		v.checkValidator(n.field("validate"), validatorType(validatorFrom.Validator), where+".validate")
+		v.checkSeverity(n.field("severity"), c.Severity, where)
+	}
+	profilesNode := root.field("profiles")
+	for _, name := range profilesNode.keysOrNil() {
+		if extends := conf.Profiles[name].Extends; extends != "" {
+			if _, exists := conf.Profiles[extends]; !exists {
+				v.errorf(profilesNode.field(name).field("extends"), "profiles.%s: unknown profile %s", name, extends)
+			}
+		}
+	}
+	if conf.DefaultProfile != "" {
+		if _, exists := conf.Profiles[conf.DefaultProfile]; !exists {
+			v.errorf(root.field("defaultProfile"), "unknown default profile %s", conf.DefaultProfile)
+		}
+	}
+	macrosNode := root.field("macros")
+	for _, name := range macrosNode.keysOrNil() {
+		v.checkValidator(macrosNode.field(name).field("validate"), validatorType(conf.Macros[name].Validator), "macros."+name+".validate")
+		v.checkSeverity(macrosNode.field(name).field("severity"), conf.Macros[name].Severity, "macros."+name)
+	}
+	return v.err()
+}
+
+// validateMacroFile checks a macro file for unknown fields.  Validators are checked where the macros are used,
+// since a macro's validator type may come from the macro it extends.
+func validateMacroFile(filename string, content []byte) error {
+	root, err := parseConfigNodes(filename, content)
+	if err != nil {
+		return err
+	}
+	v := &configValidation{
+		filename: filename,
+	}
+	for _, key := range root.keysOrNil() {
+		if key != "macros" {
+			v.errorf(root.field(key), "macro files may only contain macros, not %s", key)
+		}
+	}
+	v.checkFields(root.field("macros"), reflect.TypeOf(map[string]check{}), "macros")
+	return v.err()
+}
+
+func (n *configNode) itemsOrNil() []*configNode {
+	if n == nil {
+		return nil
+	}
+	return n.items
+}
+
+func (n *configNode) keysOrNil() []string {
+	if n == nil {
+		return nil
+	}
+	return n.keys
+}
+
+func validatorType(raw json.RawMessage) string {
+	var v validator
+	if raw == nil || json.Unmarshal(raw, &v) != nil {
+		return ""
+	}
+	return v.Type
+}
+
+func (v *configValidation) checkValidator(n *configNode, typ string, where string) {
+	if n == nil {
+		return
+	}
+	if ownType := n.field("type"); ownType != nil {
+		typ, _ = ownType.value.(string)
+	}
+	newValidator, exists := validatorTypes[typ]
+	if !exists {
+		names := make([]string, 0, len(validatorTypes))
+		for name := range validatorTypes {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		v.errorf(n.field("type"), "%s: unknown validate type %s: expected one of %s", where, typ, strings.Join(names, ", "))
+		return
+	}
+	v.checkFields(n, reflect.TypeOf(newValidator()), where)
+	if typ == "regex" {
+		v.checkRegexPatterns(n, where)
+	}
+}
+
+func (v *configValidation) checkSeverity(n *configNode, severity string, where string) {
+	if severity != "" && !containsStr(severities, severity) {
+		v.errorf(n, "%s: unknown severity %s: expected one of %s", where, severity, strings.Join(severities, ", "))
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// jsonFields returns the json names of the fields of the struct t, including those of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for name, ft := range jsonFields(f.Type) {
+				fields[name] = ft
+			}
+			continue
+		}
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		fields[tag] = f.Type
+	}
+	return fields
+}
+
+// checkFields reports object keys in n that are not fields of t.  Matching is case sensitive, even though
+// encoding/json is not, so that typos like ignoredir are caught.
+func (v *configValidation) checkFields(n *configNode, t reflect.Type, where string) {
+	if n == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		// Validators are checked once their type is known
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		for _, key := range n.keys {
+			fieldType, exists := fields[key]
+			if !exists {
+				msg := fmt.Sprintf("%s: unknown field %q", where, key)
+				for name := range fields {
+					if strings.EqualFold(name, key) {
+						msg += fmt.Sprintf(" (did you mean %q?)", name)
+					}
+				}
+				v.errorf(n.fields[key], "%s", msg)
+				continue
+			}
+			v.checkFields(n.fields[key], fieldType, where+"."+key)
+		}
+	case reflect.Map:
+		for _, key := range n.keys {
+			v.checkFields(n.fields[key], t.Elem(), where+"."+key)
+		}
+	case reflect.Slice:
+		for i, item := range n.items {
+			v.checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", where, i))
+		}
+	}
+}
+
+// parseConfigNodes parses a config file into configNodes, using the same formats as decodeConfigFile
+func parseConfigNodes(filename string, content []byte) (*configNode, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+		return yamlConfigNode(&doc), nil
+	case ".toml":
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(content), &m); err != nil {
+			return nil, err
+		}
+		l := &tomlLocator{
+			lines: strings.Split(string(content), "\n"),
+		}
+		return l.node(m, nil, filePos{line: 1, col: 1}), nil
+	default:
+		p := &jsonNodeParser{
+			content: content,
+			dec:     json.NewDecoder(bytes.NewReader(content)),
+		}
+		p.lineStarts = append(p.lineStarts, 0)
+		for i, b := range content {
+			if b == '\n' {
+				p.lineStarts = append(p.lineStarts, i+1)
+			}
+		}
+		return p.parseValue()
+	}
+}
+
+type jsonNodeParser struct {
+	content    []byte
+	dec        *json.Decoder
+	lineStarts []int
+}
+
+// nextPos is the position of the next token the decoder will return
+func (p *jsonNodeParser) nextPos() filePos {
+	offset := int(p.dec.InputOffset())
+	for offset < len(p.content) && strings.IndexByte(" \t\r\n,:", p.content[offset]) >= 0 {
+		offset++
+	}
+	line := sort.Search(len(p.lineStarts), func(i int) bool {
+		return p.lineStarts[i] > offset
+	})
+	return filePos{
+		line: line,
+		col:  offset - p.lineStarts[line-1] + 1,
+	}
+}
+
+func (p *jsonNodeParser) parseValue() (*configNode, error) {
+	n := &configNode{
+		pos: p.nextPos(),
+	}
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for p.dec.More() {
+			keyPos := p.nextPos()
+			keyTok, err := p.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			child, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			child.pos = keyPos
+			n.addField(keyTok.(string), child)
+		}
+		_, err = p.dec.Token()
+	case json.Delim('['):
+		for p.dec.More() {
+			item, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		_, err = p.dec.Token()
+	default:
+		n.value = tok
+	}
+	return n, err
+}
+
+func yamlConfigNode(y *yaml.Node) *configNode {
+	for y.Kind == yaml.DocumentNode || y.Kind == yaml.AliasNode {
+		if y.Kind == yaml.AliasNode {
+			y = y.Alias
+		} else if len(y.Content) > 0 {
+			y = y.Content[0]
+		} else {
+			return nil
+		}
+	}
+	n := &configNode{
+		pos: filePos{line: y.Line, col: y.Column},
+	}
+	switch y.Kind {
+	case yaml.MappingNode:
+		n.fields = make(map[string]*configNode)
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			key := y.Content[i]
+			child := yamlConfigNode(y.Content[i+1])
+			if child == nil {
+				continue
+			}
+			if key.Value == "<<" {
+				// Merge keys bring in the fields of another mapping
+				for _, k := range child.keys {
+					n.addField(k, child.fields[k])
+				}
+				continue
+			}
+			child.pos = filePos{line: key.Line, col: key.Column}
+			n.addField(key.Value, child)
+		}
+	case yaml.SequenceNode:
+		for _, item := range y.Content {
+			if child := yamlConfigNode(item); child != nil {
+				n.items = append(n.items, child)
+			}
+		}
+	default:
+		n.value = y.Value
+	}
+	return n
+}
+
+// tomlLocator finds where TOML keys are in the file.  The TOML decoder does not report positions, so keys are
+// found by searching the text from where their parent starts, which is exact for all but unusual layouts.
+type tomlLocator struct {
+	lines []string
+}
+
+func (l *tomlLocator) find(pattern *regexp.Regexp, fromLine int, nth int) filePos {
+	for i := fromLine - 1; i >= 0 && i < len(l.lines); i++ {
+		if loc := pattern.FindStringSubmatchIndex(l.lines[i]); loc != nil {
+			if nth == 0 {
+				return filePos{line: i + 1, col: loc[2] + 1}
+			}
+			nth--
+		}
+	}
+	return filePos{line: fromLine, col: 1}
+}
+
+func (l *tomlLocator) node(v interface{}, path []string, pos filePos) *configNode {
+	n := &configNode{
+		pos: pos,
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			keyPattern := regexp.MustCompile(`(?:^|[\s.{,\[])"?(` + regexp.QuoteMeta(k) + `)"?\s*(?:=|\]|\.)`)
+			childPos := l.find(keyPattern, pos.line, 0)
+			n.addField(k, l.node(val[k], append(path, k), childPos))
+		}
+	case []map[string]interface{}:
+		// Arrays of tables start at their i'th [[header]]
+		header := regexp.MustCompile(`^\s*\[\[\s*(` + regexp.QuoteMeta(strings.Join(path, ".")) + `)\s*\]\]`)
+		for i, item := range val {
+			n.items = append(n.items, l.node(item, path, l.find(header, 1, i)))
+		}
+	case []interface{}:
+		for _, item := range val {
+			n.items = append(n.items, l.node(item, path, pos))
+		}
+	default:
+		n.value = v
+	}
+	return n
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// fileVar is replaced with each file, the same as $1, when the check runs
+const fileVar = "${file}"
+
+// varExpander expands ${VAR} and ${VAR:-default} in config values.  Built in variables take precedence over
+// environment variables.  $${ is a literal ${.
+type varExpander struct {
+	builtins  map[string]string
+	lookupEnv func(string) (string, bool)
+}
+
+var modulePattern = regexp.MustCompile(`(?m)^module\s+"?([^"\s]+)"?`)
+
+func newVarExpander(rootPath string) *varExpander {
+	builtins := map[string]string{
+		"root":   rootPath,
+		"goos":   runtime.GOOS,
+		"goarch": runtime.GOARCH,
+	}
+	if goMod, err := ioutil.ReadFile(filepath.Join(rootPath, "go.mod")); err == nil {
+		if matches := modulePattern.FindSubmatch(goMod); matches != nil {
+			builtins["module"] = string(matches[1])
+		}
+	}
+	return &varExpander{
+		builtins:  builtins,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (v *varExpander) lookup(name string) (string, bool) {
+	if v == nil {
+		return os.LookupEnv(name)
+	}
+	if val, exists := v.builtins[name]; exists {
+		return val, true
+	}
+	return v.lookupEnv(name)
+}
+
+// expand replaces the variables in s.  ${file} and ${coverprofile} are left for when the check runs.
+func (v *varExpander) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var ret strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			ret.WriteString(s)
+			return ret.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			ret.WriteString(s[:start-1] + "${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", s)
+		}
+		ret.WriteString(s[:start])
+		ref := s[start : start+end+1]
+		s = s[start+end+1:]
+		if ref == fileVar || ref == coverProfileVar {
+			ret.WriteString(ref)
+			continue
+		}
+		name := ref[2 : len(ref)-1]
+		def, hasDefault := "", false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, def, hasDefault = name[:i], name[i+2:], true
+		}
+		if !varNamePattern.MatchString(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		val, exists := v.lookup(name)
+		if !exists || (val == "" && hasDefault) {
+			if !hasDefault {
+				return "", fmt.Errorf("variable %s is not set: use ${%s:-default} to give a default", name, name)
+			}
+			val = def
+		}
+		ret.WriteString(val)
+	}
+}
+
+func (v *varExpander) expandAll(args []string) ([]string, error) {
+	if args == nil {
+		return nil, nil
+	}
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		var err error
+		if ret[i], err = v.expand(arg); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (v *varExpander) expandCheckCmd(c *checkCmd) (*checkCmd, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cmd, err := v.expand(c.Cmd)
+	if err != nil {
+		return nil, err
+	}
+	args, err := v.expandAll(c.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &checkCmd{
+		Cmd:  cmd,
+		Args: args,
+	}, nil
+}
+
+// expandJSON expands the variables in every string of a validator.  A string that is only a variable and expands
+// to a number or boolean becomes that number or boolean, so numeric settings can come from the environment.
+func (v *varExpander) expandJSON(raw json.RawMessage) (json.RawMessage, error) {
+	if raw == nil || !strings.Contains(string(raw), "${") {
+		return raw, nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	expanded, err := v.expandValue(generic)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(expanded)
+}
+
+func (v *varExpander) expandValue(val interface{}) (interface{}, error) {
+	switch typed := val.(type) {
+	case string:
+		expanded, err := v.expand(typed)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(typed, "${") && strings.Index(typed, "}") == len(typed)-1 {
+			if f, err := strconv.ParseFloat(expanded, 64); err == nil {
+				return f, nil
+			}
+			if b, err := strconv.ParseBool(expanded); err == nil {
+				return b, nil
+			}
+		}
+		return expanded, nil
+	case []interface{}:
+		for i := range typed {
+			var err error
+			if typed[i], err = v.expandValue(typed[i]); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range typed {
+			var err error
+			if typed[k], err = v.expandValue(typed[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return val, nil
+}
+
+// expandVars expands the variables in the commands and args of the check
+func (c *check) expandVars(v *varExpander) error {
+	var err error
+	if c.Cmd, err = v.expand(c.Cmd); err != nil {
+		return err
+	}
+	for _, cmd := range []**checkCmd{&c.Fix, &c.Check, &c.Install} {
+		if *cmd, err = v.expandCheckCmd(*cmd); err != nil {
+			return err
+		}
+	}
+	then := make([]*checkCmd, len(c.Then))
+	for i := range c.Then {
+		if then[i], err = v.expandCheckCmd(c.Then[i]); err != nil {
+			return err
+		}
+	}
+	c.Then = then
+	if c.Dir, err = v.expand(c.Dir); err != nil {
+		return err
+	}
+	if len(c.Env) > 0 {
+		env := make(map[string]string, len(c.Env))
+		for k, val := range c.Env {
+			if env[k], err = v.expand(val); err != nil {
+				return err
+			}
+		}
+		c.Env = env
+	}
+	if c.Each != nil {
+		each := *c.Each
+		if each.Cmd, err = v.expand(each.Cmd); err != nil {
+			return err
+		}
+		if each.Args, err = v.expandAll(each.Args); err != nil {
+			return err
+		}
+		c.Each = &each
+	}
+	if c.When != nil {
+		when := *c.When
+		if when.Goos, err = v.expandAll(when.Goos); err != nil {
+			return err
+		}
+		if when.Goarch, err = v.expandAll(when.Goarch); err != nil {
+			return err
+		}
+		if when.GoVersion, err = v.expand(when.GoVersion); err != nil {
+			return err
+		}
+		c.When = &when
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// condition limits a check to some platforms.  Every set field must match for the check to run.  goos, goarch
+// and env entries starting with ! must not match.
+type condition struct {
+	Goos   []string `json:"goos,omitempty" yaml:"goos,omitempty"`
+	Goarch []string `json:"goarch,omitempty" yaml:"goarch,omitempty"`
+	// GoVersion is the minimum go toolchain version, like 1.22
+	GoVersion string `json:"goVersion,omitempty" yaml:"goVersion,omitempty"`
+	// Env are environment variables that must be set
+	Env []string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Cgo requires cgo to be enabled, or disabled, in the check's environment
+	Cgo *bool `json:"cgo,omitempty" yaml:"cgo,omitempty"`
+}
+
+func matchesAny(value string, patterns []string) (bool, string) {
+	var allowed []string
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if value == p[1:] {
+				return false, fmt.Sprintf("%s is excluded", value)
+			}
+			continue
+		}
+		allowed = append(allowed, p)
+		if value == p {
+			return true, ""
+		}
+	}
+	if len(allowed) == 0 {
+		return true, ""
+	}
+	return false, fmt.Sprintf("%s is not one of %s", value, strings.Join(allowed, ", "))
+}
+
+// parseGoVersion returns the numbers of a go version like go1.22.3, 1.22 or go1.23rc1
+func parseGoVersion(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "go")
+	var ret []int
+	for _, part := range strings.Split(v, ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			break
+		}
+		ret = append(ret, n)
+		if end < len(part) {
+			break
+		}
+	}
+	return ret
+}
+
+// goVersionAtLeast returns true if the go version have is the same as or newer than want
+func goVersionAtLeast(have string, want string) bool {
+	h, w := parseGoVersion(have), parseGoVersion(want)
+	for i := range w {
+		if i >= len(h) || h[i] < w[i] {
+			return i < len(h) && h[i] > w[i]
+		}
+		if h[i] > w[i] {
+			return true
+		}
+	}
+	return true
+}
+
+// goEnv returns the value of `go env name` in the check's directory and environment
+func (p *goverify) goEnv(c check, name string) (string, error) {
+	cmd := c.command("go", "env", name)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := p.run(cmd); err != nil {
+		return "", &checkResult{
+			checkName:   "go env " + name,
+			output:      stdout.String() + stderr.String(),
+			originalErr: err,
+		}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// skipReason returns why the check should not run on this platform, or the empty string if it should
+func (p *goverify) skipReason(c check) (string, error) {
+	w := c.When
+	if w == nil {
+		return "", nil
+	}
+	if ok, reason := matchesAny(runtime.GOOS, w.Goos); !ok {
+		return "goos " + reason, nil
+	}
+	if ok, reason := matchesAny(runtime.GOARCH, w.Goarch); !ok {
+		return "goarch " + reason, nil
+	}
+	for _, env := range w.Env {
+		name := strings.TrimPrefix(env, "!")
+		_, set := c.Env[name]
+		if !set {
+			_, set = os.LookupEnv(name)
+		}
+		if set && name != env {
+			return fmt.Sprintf("%s is set", name), nil
+		}
+		if !set && name == env {
+			return fmt.Sprintf("%s is not set", name), nil
+		}
+	}
+	if w.GoVersion != "" {
+		version, err := p.goEnv(c, "GOVERSION")
+		if err != nil {
+			return "", err
+		}
+		if !goVersionAtLeast(version, w.GoVersion) {
+			return fmt.Sprintf("go version %s is older than %s", version, w.GoVersion), nil
+		}
+	}
+	if w.Cgo != nil {
+		cgo, err := p.goEnv(c, "CGO_ENABLED")
+		if err != nil {
+			return "", err
+		}
+		if enabled := cgo == "1"; enabled != *w.Cgo {
+			if enabled {
+				return "cgo is enabled", nil
+			}
+			return "cgo is disabled", nil
+		}
+	}
+	return "", nil
+}